@@ -0,0 +1,287 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor, which the audit log
+// records for the changes made by the statements run with it; see
+// EnableAudit.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// AuditConfig configures EnableAudit.
+type AuditConfig struct {
+	// Tables are the tables of the main database whose changes are
+	// recorded.
+	Tables []string
+	// Target is the table the changes are recorded in, "_audit" if empty.
+	Target string
+}
+
+// EnableAudit makes db record every row inserted, updated or deleted in the
+// tables of cfg into the audit table cfg.Target, created if missing:
+//
+//	CREATE TABLE _audit (
+//		id         INTEGER PRIMARY KEY,
+//		at         TEXT NOT NULL,    -- UTC time, e.g. 2006-01-02T15:04:05.000Z
+//		actor      TEXT,             -- from WithActor, NULL without one
+//		table_name TEXT NOT NULL,
+//		op         TEXT NOT NULL,    -- INSERT, UPDATE or DELETE
+//		row_id     INTEGER,          -- NULL for WITHOUT ROWID tables
+//		old        TEXT,             -- JSON object of the row before the change
+//		new        TEXT              -- JSON object of the row after the change
+//	)
+//
+// The changes are recorded by AFTER triggers named <target>_<table>_insert,
+// _update and _delete, so they include the changes of other triggers and
+// foreign key actions, are rolled back with them, and are made by every
+// client of the database, e.g. the sqlite3 shell. Unlike a pre-update hook,
+// triggers write the audit rows in the transaction of the change. In the
+// JSON objects, BLOB values are stored as hexadecimal text.
+//
+// The triggers are plain SQL and record no actor. The connections of db get
+// a TEMP trigger on the audit table, installed when they are opened or taken
+// from the pool, that sets the actor of the rows inserted into it to the one
+// of the context of the statement, see WithActor. A connection held in a
+// sql.Conn since before EnableAudit gets it once back in the pool. Changes
+// made by other clients, or without an actor, have a NULL actor.
+//
+// EnableAudit replaces the triggers it created before, so it must be called
+// again after columns are added to an audited table. Dropping the triggers
+// stops the recording.
+func EnableAudit(ctx context.Context, db *sql.DB, cfg AuditConfig) error {
+	if cfg.Target == "" {
+		cfg.Target = "_audit"
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var connector *Connector
+	_ = conn.Raw(func(dc any) error {
+		if sc, ok := dc.(*SQLiteConn); ok {
+			connector = sc.connector
+		}
+		return nil
+	})
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+quoteIdent(cfg.Target)+` (
+	id INTEGER PRIMARY KEY,
+	at TEXT NOT NULL,
+	actor TEXT,
+	table_name TEXT NOT NULL,
+	op TEXT NOT NULL,
+	row_id INTEGER,
+	old TEXT,
+	new TEXT
+)`)
+	if err != nil {
+		return err
+	}
+	for _, table := range cfg.Tables {
+		if table == cfg.Target {
+			return fmt.Errorf("sqlite3: audit: cannot audit the audit table %q", table)
+		}
+		stmts, err := auditTriggers(ctx, tx, cfg.Target, table)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if connector == nil {
+		return nil
+	}
+	connector.addAuditTarget(cfg.Target)
+	return conn.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).installActorTriggers(ctx)
+	})
+}
+
+// auditTriggers returns the statements replacing the audit triggers of
+// table.
+func auditTriggers(ctx context.Context, tx *sql.Tx, target, table string) ([]string, error) {
+	var typ string
+	var withoutRowID bool
+	err := tx.QueryRowContext(ctx, `SELECT type, wr FROM pragma_table_list WHERE schema = 'main' AND name = ?`, table).Scan(&typ, &withoutRowID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && typ != "table" {
+		return nil, fmt.Errorf("sqlite3: audit: no table %q", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cols []string
+	err = queryEach(ctx, tx, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		cols = append(cols, name)
+		return nil
+	}, `SELECT name FROM pragma_table_xinfo(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	row := func(ref string) string {
+		var b strings.Builder
+		b.WriteString("json_object(")
+		for i, col := range cols {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			v := ref + "." + quoteIdent(col)
+			fmt.Fprintf(&b, "%s, CASE WHEN typeof(%s) = 'blob' THEN hex(%s) ELSE %s END", quoteString(col), v, v, v)
+		}
+		b.WriteString(")")
+		return b.String()
+	}
+	var stmts []string
+	for _, t := range []struct{ op, rowID, old, new string }{
+		{"INSERT", "NEW.rowid", "NULL", row("NEW")},
+		{"UPDATE", "NEW.rowid", row("OLD"), row("NEW")},
+		{"DELETE", "OLD.rowid", row("OLD"), "NULL"},
+	} {
+		if withoutRowID {
+			t.rowID = "NULL"
+		}
+		name := quoteIdent(target + "_" + table + "_" + strings.ToLower(t.op))
+		stmts = append(stmts, "DROP TRIGGER IF EXISTS "+name, fmt.Sprintf(
+			`CREATE TRIGGER %s AFTER %s ON %s BEGIN
+	INSERT INTO %s (at, actor, table_name, op, row_id, old, new)
+	VALUES (strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ', 'now'), NULL, %s, '%s', %s, %s, %s);
+END`, name, t.op, quoteIdent(table), quoteIdent(target), quoteString(table), t.op, t.rowID, t.old, t.new))
+	}
+	return stmts, nil
+}
+
+// quoteString quotes s as an SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// addAuditTarget makes the connections of c set the actor of the rows
+// inserted into the audit table target.
+func (c *Connector) addAuditTarget(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.auditTargets, target) {
+		c.auditTargets = append(c.auditTargets, target)
+	}
+}
+
+// useActor makes audit_actor() return the actor of ctx, if any, until
+// restore is called, which must be right after the statement of ctx made
+// its changes: the actor is bound to the statement, not to the rows of the
+// queries left open on c.
+func (c *SQLiteConn) useActor(ctx context.Context) (restore func()) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	if !ok {
+		return func() {}
+	}
+	c.actor = &actor
+	return func() { c.actor = nil }
+}
+
+// installActorTriggers installs on c the actor triggers of the audit tables
+// EnableAudit added since the last call. It runs when c is opened and taken
+// from the pool, outside of the statements, and bypasses the authorizer of
+// c. Read-only connections, which make no changes to record, and
+// connections in a transaction, which could roll the triggers back, are
+// skipped until the next call.
+func (c *SQLiteConn) installActorTriggers(ctx context.Context) error {
+	if c.connector == nil {
+		return nil
+	}
+	c.connector.mu.Lock()
+	targets := c.connector.auditTargets[c.auditTargets:]
+	c.connector.mu.Unlock()
+	if len(targets) == 0 || c.inTx() {
+		return nil
+	}
+	if c.authDB != 0 {
+		h, err := handleOf(c.conn)
+		if err != nil {
+			return err
+		}
+		sqlite3.Xsqlite3_set_authorizer(h.tls, h.db, 0, 0)
+		defer sqlite3.Xsqlite3_set_authorizer(h.tls, h.db, cFuncPointer(authorizer), h.db)
+	}
+	var queryOnly int64
+	if err := c.queryRow(ctx, "PRAGMA query_only", &queryOnly); err != nil || queryOnly != 0 {
+		return err
+	}
+	for _, target := range targets {
+		if _, err := c.conn.ExecContext(ctx, fmt.Sprintf(`CREATE TEMP TRIGGER IF NOT EXISTS %s AFTER INSERT ON main.%s WHEN audit_actor() IS NOT NULL BEGIN
+	UPDATE %s SET actor = audit_actor() WHERE id = NEW.id;
+END`, quoteIdent(target+"_actor"), quoteIdent(target), quoteIdent(target)), nil); err != nil {
+			return fmt.Errorf("sqlite3: audit: installing the actor trigger of %s: %w", target, err)
+		}
+		c.auditTargets++
+	}
+	return nil
+}
+
+// registerAuditActor registers the SQL function audit_actor() of the actor
+// triggers on c, which must have its busy handler set.
+func (c *SQLiteConn) registerAuditActor() error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	name, err := libc.CString("audit_actor")
+	if err != nil {
+		return err
+	}
+	defer libc.Xfree(h.tls, name)
+	// Innocuous, so that triggers can call it with PRAGMA trusted_schema=OFF.
+	rc := sqlite3.Xsqlite3_create_function_v2(h.tls, h.db, name, 0, sqlite3.SQLITE_UTF8|sqlite3.SQLITE_INNOCUOUS, h.db, cFuncPointer(auditActor), 0, 0, 0)
+	if rc != sqlite3.SQLITE_OK {
+		return h.errorOf(rc)
+	}
+	return nil
+}
+
+// auditActor implements audit_actor(), returning the actor of the statement
+// running, see useActor, or NULL.
+func auditActor(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+	c := busyConn(sqlite3.Xsqlite3_user_data(tls, ctx))
+	if c == nil || c.actor == nil {
+		sqlite3.Xsqlite3_result_null(tls, ctx)
+		return
+	}
+	actor := *c.actor
+	s, err := libc.CString(actor)
+	if err != nil {
+		sqlite3.Xsqlite3_result_error_nomem(tls, ctx)
+		return
+	}
+	defer libc.Xfree(tls, s)
+	sqlite3.Xsqlite3_result_text(tls, ctx, s, int32(len(actor)), sqlite3.SQLITE_TRANSIENT)
+}
@@ -0,0 +1,281 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnableAudit(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:audit?mode=memory&cache=shared&_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id) ON DELETE CASCADE, body BLOB);
+		CREATE TABLE tags (name TEXT PRIMARY KEY) WITHOUT ROWID;
+		CREATE TABLE other (x)`); err != nil {
+		t.Fatal(err)
+	}
+	cfg := AuditConfig{Tables: []string{"users", "posts", "tags"}}
+	if err := EnableAudit(ctx, db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Enabling again replaces the triggers.
+	if err := EnableAudit(ctx, db, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := WithActor(ctx, "alice")
+	if _, err := db.ExecContext(alice, `INSERT INTO users VALUES (1, 'foo', 1.5)`); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err := db.QueryRowContext(WithActor(ctx, "bob"), `INSERT INTO posts VALUES (10, 1, x'0102') RETURNING id`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(alice, `UPDATE users SET name = 'bar' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO tags VALUES ('go')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(alice, `DELETE FROM tags`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(alice, `DELETE FROM users WHERE id = 1; INSERT INTO other VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		actor, table, op string
+		rowID            any
+		old, new         any
+	}
+	var got []entry
+	err = queryEach(ctx, db, func(rows *sql.Rows) error {
+		var e entry
+		var at string
+		if err := rows.Scan(&at, &e.actor, &e.table, &e.op, &e.rowID, &e.old, &e.new); err != nil {
+			return err
+		}
+		if _, err := time.Parse("2006-01-02T15:04:05.000Z", at); err != nil {
+			t.Errorf("expected a UTC time, but got %q", at)
+		}
+		got = append(got, e)
+		return nil
+	}, `SELECT at, coalesce(actor, ''), table_name, op, row_id, old, new FROM _audit ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []entry{
+		{"alice", "users", "INSERT", int64(1), nil, `{"id":1,"name":"foo","score":1.5}`},
+		{"bob", "posts", "INSERT", int64(10), nil, `{"id":10,"user_id":1,"body":"0102"}`},
+		{"alice", "users", "UPDATE", int64(1), `{"id":1,"name":"foo","score":1.5}`, `{"id":1,"name":"bar","score":1.5}`},
+		{"", "tags", "INSERT", nil, nil, `{"name":"go"}`},
+		{"alice", "posts", "DELETE", int64(10), `{"id":10,"user_id":1,"body":"0102"}`, nil},
+		{"alice", "users", "DELETE", int64(1), `{"id":1,"name":"bar","score":1.5}`, nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+
+	// Other clients can change the audited tables, without an actor.
+	other, err := sql.Open("sqlite", "file:audit?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.ExecContext(alice, `INSERT INTO users VALUES (2, 'baz', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	var actor sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT actor FROM _audit WHERE table_name = 'users' AND row_id = 2`).Scan(&actor); err != nil {
+		t.Fatal(err)
+	}
+	if actor.Valid {
+		t.Fatalf("expected no actor, but got %q", actor.String)
+	}
+
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"missing"}}); err == nil {
+		t.Fatal("expected auditing a missing table to fail")
+	}
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"_audit"}}); err == nil {
+		t.Fatal("expected auditing the audit table to fail")
+	}
+}
+
+func TestEnableAuditTarget(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:audittarget?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE "it's" (a)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"it's"}, Target: "log"}); err != nil {
+		t.Fatal(err)
+	}
+	// Columns added later are recorded once the audit is enabled again.
+	if _, err := db.ExecContext(ctx, `ALTER TABLE "it's" ADD COLUMN "b""c"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"it's"}, Target: "log"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO "it's" VALUES (1, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	var table, row string
+	if err := db.QueryRowContext(ctx, `SELECT table_name, new FROM log`).Scan(&table, &row); err != nil {
+		t.Fatal(err)
+	}
+	if table != "it's" || row != `{"a":1,"b\"c":2}` {
+		t.Fatalf("expected it's {\"a\":1,\"b\\\"c\":2}, but got %s %s", table, row)
+	}
+}
+
+func TestEnableAuditInterleaved(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:auditinterleaved?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (n INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The actor of rows left open does not apply to the statements run
+	// while they are read.
+	rows, err := conn.QueryContext(WithActor(ctx, "alice"), `INSERT INTO test VALUES (1), (2) RETURNING n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO test VALUES (3)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(WithActor(ctx, "bob"), `INSERT INTO test VALUES (4)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = queryEach(ctx, conn, func(rows *sql.Rows) error {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return err
+		}
+		got = append(got, actor)
+		return nil
+	}, `SELECT coalesce(actor, '') FROM _audit ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "alice", "", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the actors %q, but got %q", want, got)
+	}
+}
+
+func TestEnableAuditActorTrigger(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	sandbox, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sandbox.Close()
+	err = sandbox.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).SetAuthorizer(ReadOnlyAuthorizer(map[string][]string{"users": {"*"}}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAudit(ctx, db, AuditConfig{Tables: []string{"users"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Statements with an actor install nothing, so they run on
+	// connections that cannot change the schema.
+	alice := WithActor(ctx, "alice")
+	var count int
+	if err := sandbox.QueryRowContext(alice, `SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		t.Fatalf("expected a read-only query with an actor to run, but got %v", err)
+	}
+	if _, err := sandbox.ExecContext(ctx, `PRAGMA query_only = 1`); err == nil {
+		t.Fatal("expected the authorizer to deny PRAGMA")
+	}
+
+	// New connections have the actor trigger before their first statement.
+	conns := make([]*sql.Conn, 2)
+	for i := range conns {
+		if conns[i], err = db.Conn(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+		if err := conns[i].QueryRowContext(ctx, `SELECT COUNT(*) FROM temp.sqlite_schema WHERE name = '_audit_actor'`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("expected the actor trigger on connection %d, but got %d", i, count)
+		}
+	}
+	if _, err := conns[1].ExecContext(ctx, `PRAGMA query_only = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conns[1].QueryRowContext(alice, `SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		t.Fatalf("expected a query with an actor to run with query_only, but got %v", err)
+	}
+	if _, err := conns[0].ExecContext(alice, `INSERT INTO users (name) VALUES ('a')`); err != nil {
+		t.Fatal(err)
+	}
+	var actor string
+	if err := db.QueryRowContext(ctx, `SELECT actor FROM _audit`).Scan(&actor); err != nil {
+		t.Fatal(err)
+	}
+	if actor != "alice" {
+		t.Fatalf("expected the actor alice, but got %q", actor)
+	}
+}
@@ -0,0 +1,144 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"strings"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// AuthResult is the decision of an authorizer about an action.
+type AuthResult int
+
+const (
+	// AuthOK allows the action.
+	AuthOK AuthResult = sqlite3.SQLITE_OK
+	// AuthDeny fails the preparation of the statement with an error.
+	AuthDeny AuthResult = sqlite3.SQLITE_DENY
+	// AuthIgnore lets the statement run without the action: a column read
+	// yields NULL, a row deletion is skipped.
+	AuthIgnore AuthResult = sqlite3.SQLITE_IGNORE
+)
+
+var authConns = struct {
+	sync.RWMutex
+	m map[uintptr]func(action int, arg1, arg2, db, trigger string) AuthResult
+}{m: map[uintptr]func(action int, arg1, arg2, db, trigger string) AuthResult{}}
+
+// SetAuthorizer makes the connection call fn for every action of the
+// statements it prepares, see https://sqlite.org/c3ref/set_authorizer.html.
+// action is one of the action codes of modernc.org/sqlite/lib, e.g.
+// SQLITE_READ, and arg1 and arg2 depend on it; db is the name of the
+// database, e.g. "main", and trigger the name of the trigger or view
+// responsible for the action, if any.
+//
+// Statements are authorized when prepared, so fn must not use the
+// connection. A nil fn removes the authorizer. An authorizer set by the
+// Connector is installed after the PRAGMAs of the DSN ran.
+func (c *SQLiteConn) SetAuthorizer(fn func(action int, arg1, arg2, db, trigger string) AuthResult) error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	if fn == nil {
+		sqlite3.Xsqlite3_set_authorizer(h.tls, h.db, 0, 0)
+		c.clearAuthorizer()
+		return nil
+	}
+	authConns.Lock()
+	authConns.m[h.db] = fn
+	authConns.Unlock()
+	sqlite3.Xsqlite3_set_authorizer(h.tls, h.db, cFuncPointer(authorizer), h.db)
+	c.authDB = h.db
+	return nil
+}
+
+// clearAuthorizer forgets the authorizer of a connection being closed.
+func (c *SQLiteConn) clearAuthorizer() {
+	if c.authDB == 0 {
+		return
+	}
+	authConns.Lock()
+	delete(authConns.m, c.authDB)
+	authConns.Unlock()
+	c.authDB = 0
+}
+
+func authorizer(tls *libc.TLS, db uintptr, action int32, arg1, arg2, dbName, trigger uintptr) int32 {
+	authConns.RLock()
+	fn := authConns.m[db]
+	authConns.RUnlock()
+	if fn == nil {
+		return sqlite3.SQLITE_DENY
+	}
+	return int32(fn(int(action), libc.GoString(arg1), libc.GoString(arg2), libc.GoString(dbName), libc.GoString(trigger)))
+}
+
+// readOnlyPragmas are the PRAGMAs the driver itself reads, e.g. in
+// SQLiteConn.JournalMode and RebuildTable, which ReadOnlyAuthorizer allows
+// without an argument.
+var readOnlyPragmas = map[string]bool{
+	"foreign_keys": true,
+	"journal_mode": true,
+	"query_only":   true,
+}
+
+// sideEffectFunctions are the built-in SQL functions that change the state
+// of the connection, which ReadOnlyAuthorizer denies.
+var sideEffectFunctions = map[string]bool{
+	"fts3_tokenizer": true,
+	"load_extension": true,
+}
+
+// ReadOnlyAuthorizer returns an authorizer for user-supplied SQL that allows
+// SELECT statements reading the columns of tables lists, a map from table
+// names to column names, with "*" standing for every column of the table.
+// Names are matched case-insensitively.
+//
+// It allows functions, recursive common table expressions, transactions and
+// reading the foreign_keys, journal_mode and query_only PRAGMAs the driver
+// runs, and denies everything else: reading other tables or the schema,
+// ATTACH, other PRAGMAs and any change to the data or the schema. Of the
+// functions, the built-in load_extension and fts3_tokenizer are denied;
+// functions registered by the application are allowed, so they must have
+// no side effects.
+func ReadOnlyAuthorizer(tables map[string][]string) func(action int, arg1, arg2, db, trigger string) AuthResult {
+	allowed := make(map[string]map[string]bool, len(tables))
+	for table, columns := range tables {
+		cols := make(map[string]bool, len(columns))
+		for _, col := range columns {
+			cols[strings.ToLower(col)] = true
+		}
+		allowed[strings.ToLower(table)] = cols
+	}
+	return func(action int, arg1, arg2, db, trigger string) AuthResult {
+		switch action {
+		case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_RECURSIVE,
+			sqlite3.SQLITE_TRANSACTION, sqlite3.SQLITE_SAVEPOINT:
+			return AuthOK
+		case sqlite3.SQLITE_FUNCTION:
+			// The name of the function is arg2.
+			if !sideEffectFunctions[strings.ToLower(arg2)] {
+				return AuthOK
+			}
+		case sqlite3.SQLITE_PRAGMA:
+			// arg2 is the value a PRAGMA is set to.
+			if arg2 == "" && readOnlyPragmas[strings.ToLower(arg1)] && (db == "" || db == "main") {
+				return AuthOK
+			}
+		case sqlite3.SQLITE_READ:
+			// db and the column are empty when the table is read
+			// without reading any column, e.g. by COUNT(*).
+			cols, ok := allowed[strings.ToLower(arg1)]
+			if !ok || db != "main" && db != "" {
+				return AuthDeny
+			}
+			if arg2 == "" || cols["*"] || cols[strings.ToLower(arg2)] {
+				return AuthOK
+			}
+		}
+		return AuthDeny
+	}
+}
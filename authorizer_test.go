@@ -0,0 +1,135 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestReadOnlyAuthorizer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	admin, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, password TEXT);
+		CREATE TABLE secrets (id INTEGER PRIMARY KEY, value TEXT);
+		INSERT INTO users (name, password) VALUES ('a', 'secret');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(NewSandboxConnector(file, map[string][]string{"users": {"id", "Name"}}))
+	defer db.Close()
+
+	ctx := context.Background()
+	var name string
+	if err := db.QueryRowContext(ctx, `SELECT name FROM users WHERE id = 1`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "a" {
+		t.Fatalf("expected name to be 'a', but got %q", name)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE upper(name) = 'A'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowContext(ctx, `
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 3)
+		SELECT SUM(i) FROM n`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	// The PRAGMAs the driver reads are allowed.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc any) error {
+		_, err := dc.(*SQLiteConn).JournalMode()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		`SELECT password FROM users`,
+		`SELECT * FROM users`,
+		`SELECT * FROM secrets`,
+		`SELECT COUNT(*) FROM secrets`,
+		`SELECT * FROM sqlite_master`,
+		`INSERT INTO users (name) VALUES ('b')`,
+		`UPDATE users SET name = 'b'`,
+		`DELETE FROM users`,
+		`CREATE TABLE more (id INTEGER)`,
+		`DROP TABLE secrets`,
+		`PRAGMA table_info(users)`,
+		`PRAGMA journal_mode = DELETE`,
+		`PRAGMA foreign_keys = OFF`,
+		`SELECT fts3_tokenizer('simple')`,
+		`SELECT load_extension('x')`,
+		`ATTACH DATABASE ':memory:' AS other`,
+	} {
+		if _, err := db.ExecContext(ctx, query); err == nil {
+			t.Errorf("expected %q to be denied", query)
+		}
+	}
+}
+
+func TestSetAuthorizer(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE users (id INTEGER PRIMARY KEY, password TEXT); INSERT INTO users (password) VALUES ('secret')`); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Hide the passwords instead of failing the queries reading them.
+	if err := conn.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).SetAuthorizer(func(action int, arg1, arg2, db, trigger string) AuthResult {
+			if action == sqlite3.SQLITE_READ && arg2 == "password" {
+				return AuthIgnore
+			}
+			return AuthOK
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var password sql.NullString
+	if err := conn.QueryRowContext(ctx, `SELECT password FROM users`).Scan(&password); err != nil {
+		t.Fatal(err)
+	}
+	if password.Valid {
+		t.Fatalf("expected the password to be NULL, but got %q", password.String)
+	}
+
+	if err := conn.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).SetAuthorizer(nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT password FROM users`).Scan(&password); err != nil {
+		t.Fatal(err)
+	}
+	if password.String != "secret" {
+		t.Fatalf("expected the password once the authorizer is removed, but got %q", password.String)
+	}
+}
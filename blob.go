@@ -0,0 +1,333 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ZeroBlob is a statement argument binding a BLOB of that many zero bytes
+// without allocating it, to be filled afterwards with OpenBlob:
+//
+//	res, err := db.Exec(`INSERT INTO files (data) VALUES (?)`, sqlite3.ZeroBlob(size))
+//
+// It is supported by Exec only: Query fails with ErrZeroBlobQuery, so use
+// last_insert_rowid() rather than RETURNING. The other arguments of the
+// statement must not be time.Time values, which Exec rejects along with it.
+type ZeroBlob int64
+
+// ErrZeroBlobQuery is returned by Query when its arguments hold a ZeroBlob.
+var ErrZeroBlobQuery = errors.New("sqlite3: ZeroBlob arguments are supported by Exec only")
+
+// CheckNamedValue implements driver.NamedValueChecker so that ZeroBlob
+// arguments reach the driver.
+func (c *SQLiteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(ZeroBlob); ok {
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// Blob is a handle on a BLOB for incremental I/O, returned by OpenBlob. It
+// reads and writes the BLOB in place, without loading it in memory. The
+// size of the BLOB cannot be changed through it.
+//
+// A Blob belongs to the connection that opened it and must not be used
+// concurrently with it.
+type Blob struct {
+	h    sqliteHandle
+	blob uintptr // *sqlite3_blob
+	size int64
+	off  int64
+	buf  uintptr // C buffer of bufSize bytes
+}
+
+const blobBufSize = 64 << 10
+
+var (
+	_ io.ReadWriteSeeker = (*Blob)(nil)
+	_ io.ReaderAt        = (*Blob)(nil)
+	_ io.WriterAt        = (*Blob)(nil)
+	_ io.Closer          = (*Blob)(nil)
+)
+
+// OpenBlob opens the BLOB in column of the row rowid of table in the
+// database db, e.g. "main", for reading, and writing too if writable is set.
+// The Blob must be closed.
+func (c *SQLiteConn) OpenBlob(db, table, column string, rowid int64, writable bool) (*Blob, error) {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	cstrs := make([]uintptr, 3)
+	for i, s := range []string{db, table, column} {
+		if cstrs[i], err = libc.CString(s); err != nil {
+			return nil, err
+		}
+		defer libc.Xfree(h.tls, cstrs[i])
+	}
+	pblob := h.tls.Alloc(8)
+	defer h.tls.Free(8)
+
+	var flags int32
+	if writable {
+		flags = 1
+	}
+	if rc := sqlite3.Xsqlite3_blob_open(h.tls, h.db, cstrs[0], cstrs[1], cstrs[2], rowid, flags, pblob); rc != sqlite3.SQLITE_OK {
+		return nil, h.errorOf(rc)
+	}
+	b := &Blob{h: h, blob: *(*uintptr)(ptr(pblob))}
+	b.size = int64(sqlite3.Xsqlite3_blob_bytes(h.tls, b.blob))
+	if b.buf = sqlite3.Xsqlite3_malloc64(h.tls, blobBufSize); b.buf == 0 {
+		sqlite3.Xsqlite3_blob_close(h.tls, b.blob)
+		return nil, errors.New("sqlite3: out of memory")
+	}
+	return b, nil
+}
+
+// Size returns the size of the BLOB in bytes.
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("sqlite3: negative offset")
+	}
+	n := 0
+	for n < len(p) && off < b.size {
+		chunk := min(len(p)-n, blobBufSize, int(b.size-off))
+		if rc := sqlite3.Xsqlite3_blob_read(b.h.tls, b.blob, b.buf, int32(chunk), int32(off)); rc != sqlite3.SQLITE_OK {
+			return n, b.h.errorOf(rc)
+		}
+		copy(p[n:n+chunk], (*[blobBufSize]byte)(ptr(b.buf))[:chunk])
+		n += chunk
+		off += int64(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt. Writing past the end of the BLOB fails.
+func (b *Blob) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("sqlite3: negative offset")
+	}
+	if off+int64(len(p)) > b.size {
+		return 0, fmt.Errorf("sqlite3: write of %d bytes at %d past the end of a BLOB of %d bytes", len(p), off, b.size)
+	}
+	n := 0
+	for n < len(p) {
+		chunk := min(len(p)-n, blobBufSize)
+		copy((*[blobBufSize]byte)(ptr(b.buf))[:chunk], p[n:n+chunk])
+		if rc := sqlite3.Xsqlite3_blob_write(b.h.tls, b.blob, b.buf, int32(chunk), int32(off)); rc != sqlite3.SQLITE_OK {
+			return n, b.h.errorOf(rc)
+		}
+		n += chunk
+		off += int64(chunk)
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (b *Blob) Read(p []byte) (int, error) {
+	if b.off >= b.size {
+		return 0, io.EOF
+	}
+	n, err := b.ReadAt(p[:min(int64(len(p)), b.size-b.off)], b.off)
+	b.off += int64(n)
+	return n, err
+}
+
+// Write implements io.Writer.
+func (b *Blob) Write(p []byte) (int, error) {
+	n, err := b.WriteAt(p, b.off)
+	b.off += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, fmt.Errorf("sqlite3: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("sqlite3: negative offset")
+	}
+	b.off = offset
+	return offset, nil
+}
+
+// Reopen moves the Blob to the same column of the row rowid and rewinds it.
+func (b *Blob) Reopen(rowid int64) error {
+	if rc := sqlite3.Xsqlite3_blob_reopen(b.h.tls, b.blob, rowid); rc != sqlite3.SQLITE_OK {
+		return b.h.errorOf(rc)
+	}
+	b.size = int64(sqlite3.Xsqlite3_blob_bytes(b.h.tls, b.blob))
+	b.off = 0
+	return nil
+}
+
+// Close implements io.Closer.
+func (b *Blob) Close() error {
+	if b.blob == 0 {
+		return nil
+	}
+	sqlite3.Xsqlite3_free(b.h.tls, b.buf)
+	rc := sqlite3.Xsqlite3_blob_close(b.h.tls, b.blob)
+	b.blob, b.buf = 0, 0
+	if rc != sqlite3.SQLITE_OK {
+		return b.h.errorOf(rc)
+	}
+	return nil
+}
+
+// hasZeroBlob reports whether args hold a ZeroBlob.
+func hasZeroBlob(args []driver.NamedValue) bool {
+	for _, arg := range args {
+		if _, ok := arg.Value.(ZeroBlob); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// execZeroBlob runs the single statement query with args holding ZeroBlob
+// values, which modernc.org/sqlite cannot bind, through the C API. time.Time
+// values are rejected rather than formatted differently from the way
+// modernc.org/sqlite formats them, e.g. with _time_format.
+func (c *SQLiteConn) execZeroBlob(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	var allocs []uintptr
+	defer func() {
+		for _, p := range allocs {
+			libc.Xfree(h.tls, p)
+		}
+	}()
+	cstr := func(s string) (uintptr, error) {
+		p, err := libc.CString(s)
+		if err == nil {
+			allocs = append(allocs, p)
+		}
+		return p, err
+	}
+
+	zsql, err := cstr(query)
+	if err != nil {
+		return nil, err
+	}
+	pstmt := h.tls.Alloc(8)
+	defer h.tls.Free(8)
+	if rc := sqlite3.Xsqlite3_prepare_v2(h.tls, h.db, zsql, -1, pstmt, 0); rc != sqlite3.SQLITE_OK {
+		return nil, h.errorOf(rc)
+	}
+	stmt := *(*uintptr)(ptr(pstmt))
+	defer sqlite3.Xsqlite3_finalize(h.tls, stmt)
+
+	for i := int32(1); i <= sqlite3.Xsqlite3_bind_parameter_count(h.tls, stmt); i++ {
+		arg, err := argFor(libc.GoString(sqlite3.Xsqlite3_bind_parameter_name(h.tls, stmt, i)), int(i), args)
+		if err != nil {
+			return nil, err
+		}
+		var rc int32
+		switch v := arg.(type) {
+		case nil:
+			rc = sqlite3.Xsqlite3_bind_null(h.tls, stmt, i)
+		case ZeroBlob:
+			rc = sqlite3.Xsqlite3_bind_zeroblob64(h.tls, stmt, i, uint64(v))
+		case int64:
+			rc = sqlite3.Xsqlite3_bind_int64(h.tls, stmt, i, v)
+		case float64:
+			rc = sqlite3.Xsqlite3_bind_double(h.tls, stmt, i, v)
+		case bool:
+			var iv int64
+			if v {
+				iv = 1
+			}
+			rc = sqlite3.Xsqlite3_bind_int64(h.tls, stmt, i, iv)
+		case string:
+			p, err := cstr(v)
+			if err != nil {
+				return nil, err
+			}
+			rc = sqlite3.Xsqlite3_bind_text(h.tls, stmt, i, p, int32(len(v)), 0)
+		case time.Time:
+			return nil, fmt.Errorf("sqlite3: time.Time argument %d cannot be bound along with a ZeroBlob", i)
+		case []byte:
+			p, err := cstr(string(v))
+			if err != nil {
+				return nil, err
+			}
+			rc = sqlite3.Xsqlite3_bind_blob(h.tls, stmt, i, p, int32(len(v)), 0)
+		default:
+			return nil, fmt.Errorf("sqlite3: invalid driver.Value type %T", v)
+		}
+		if rc != sqlite3.SQLITE_OK {
+			return nil, h.errorOf(rc)
+		}
+	}
+
+	for {
+		switch rc := sqlite3.Xsqlite3_step(h.tls, stmt); rc {
+		case sqlite3.SQLITE_ROW:
+		case sqlite3.SQLITE_DONE:
+			return blobResult{
+				id:      sqlite3.Xsqlite3_last_insert_rowid(h.tls, h.db),
+				changes: sqlite3.Xsqlite3_changes64(h.tls, h.db),
+			}, nil
+		default:
+			return nil, h.errorOf(rc)
+		}
+	}
+}
+
+// argFor returns the value of the parameter of a statement named name, e.g.
+// ":id" or "?2", or at position i when unnamed, the way modernc.org/sqlite
+// binds args.
+func argFor(name string, i int, args []driver.NamedValue) (driver.Value, error) {
+	for _, arg := range args {
+		switch {
+		case name == "":
+			if arg.Ordinal == i {
+				return arg.Value, nil
+			}
+		case (name[0] == '?' || name[0] == '$') && name[1:] == strconv.Itoa(arg.Ordinal),
+			name[1:] == arg.Name:
+			return arg.Value, nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("missing named argument %q", name[1:])
+	}
+	return nil, fmt.Errorf("missing argument with index %d", i)
+}
+
+type blobResult struct {
+	id, changes int64
+}
+
+func (r blobResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r blobResult) RowsAffected() (int64, error) { return r.changes, nil }
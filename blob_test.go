@@ -0,0 +1,148 @@
+package sqlite3
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlob(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE files (id INTEGER PRIMARY KEY, name TEXT, data BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 200_000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	res, err := db.ExecContext(ctx, `INSERT INTO files (name, data) VALUES (?, ?)`, "a", ZeroBlob(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareContext(ctx, `INSERT INTO files (name, data) VALUES (:name, :data)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.ExecContext(ctx, sql.Named("name", "b"), sql.Named("data", ZeroBlob(10))); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc any) error {
+		c := dc.(*SQLiteConn)
+		w, err := c.OpenBlob("main", "files", "data", id, true)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		if w.Size() != int64(len(data)) {
+			t.Fatalf("expected a BLOB of %d bytes, but got %d", len(data), w.Size())
+		}
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{1}); err == nil {
+			t.Fatal("expected a write past the end to fail")
+		}
+
+		r, err := c.OpenBlob("main", "files", "data", id, false)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		got, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("expected to read back the data written")
+		}
+		p := make([]byte, 10)
+		if _, err := r.Seek(-5, io.SeekEnd); err != nil {
+			return err
+		}
+		if n, err := r.Read(p); n != 5 || err != nil {
+			t.Fatalf("expected to read the last 5 bytes, but got %d, %v", n, err)
+		}
+		if n, err := r.ReadAt(p, int64(len(data))-3); n != 3 || err != io.EOF {
+			t.Fatalf("expected 3 bytes and io.EOF, but got %d, %v", n, err)
+		}
+		if _, err := r.WriteAt(p[:1], 0); err == nil {
+			t.Fatal("expected a write to a read-only BLOB to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	if err := db.QueryRowContext(ctx, `SELECT data FROM files WHERE id = ?`, id).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("expected the BLOB to hold the data written")
+	}
+	var size int
+	if err := db.QueryRowContext(ctx, `SELECT length(data) FROM files WHERE name = 'b'`).Scan(&size); err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Fatalf("expected a BLOB of 10 bytes, but got %d", size)
+	}
+}
+
+func TestZeroBlobQuery(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE files (id INTEGER PRIMARY KEY, data BLOB, at TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	const query = `INSERT INTO files (data) VALUES (?) RETURNING id`
+	if err := db.QueryRowContext(ctx, query, ZeroBlob(10)).Scan(new(int64)); !errors.Is(err, ErrZeroBlobQuery) {
+		t.Fatalf("expected ErrZeroBlobQuery, but got %v", err)
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if err := stmt.QueryRowContext(ctx, ZeroBlob(10)).Scan(new(int64)); !errors.Is(err, ErrZeroBlobQuery) {
+		t.Fatalf("expected ErrZeroBlobQuery from a prepared statement, but got %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO files (data, at) VALUES (?, ?)`, ZeroBlob(10), time.Now()); err == nil {
+		t.Fatal("expected a time.Time along with a ZeroBlob to fail")
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM files`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no row inserted, but got %d", count)
+	}
+}
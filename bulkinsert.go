@@ -0,0 +1,217 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// BulkInsert inserts rows into the columns of table on conn and returns the
+// rowids of the rows inserted, in order. table must be a rowid table: a
+// WITHOUT ROWID table fails with an error before any row is inserted.
+//
+// When columns include the rowid, e.g. an INTEGER PRIMARY KEY column, its
+// values are the rowids returned, and they must be integers. Otherwise,
+// SQLite assigns the rowids of each chunk in a row, after the largest rowid
+// of table, and they are computed from last_insert_rowid(). BulkInsert fails
+// if they are not, e.g. because a trigger inserts into table too or because
+// the largest rowid reached 2^63-1, from which SQLite picks them at random.
+// It also fails if a conflict resolution of table, e.g. UNIQUE ON CONFLICT
+// IGNORE, skips a row, which would have no rowid.
+//
+// The rows are inserted with multi-row INSERT statements holding as many
+// rows as SQLITE_LIMIT_VARIABLE_NUMBER allows, prepared once per chunk size.
+// They run in the savepoint bulk_insert, so either all rows are inserted or
+// none is, and BulkInsert can be called inside a transaction.
+func BulkInsert(ctx context.Context, conn *sql.Conn, table string, columns []string, rows iter.Seq[[]any]) (ids []int64, err error) {
+	if len(columns) == 0 {
+		return nil, errors.New("sqlite3: bulk insert without columns")
+	}
+	maxVars := -1
+	err = conn.Raw(func(dc any) error {
+		c, ok := dc.(*SQLiteConn)
+		if !ok {
+			return fmt.Errorf("sqlite3: unexpected connection type %T", dc)
+		}
+		var err error
+		maxVars, err = c.SetLimit(LimitVariableNumber, -1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	chunk := maxVars / len(columns)
+	if chunk < 1 {
+		return nil, fmt.Errorf("sqlite3: bulk insert of %d columns exceeds the limit of %d variables", len(columns), maxVars)
+	}
+	rowidCol, err := rowidColumn(ctx, conn, table)
+	if err != nil {
+		return nil, err
+	}
+	given := slices.IndexFunc(columns, func(col string) bool {
+		switch strings.ToLower(col) {
+		case "rowid", "oid", "_rowid_", strings.ToLower(rowidCol):
+			return true
+		}
+		return false
+	})
+
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT bulk_insert"); err != nil {
+		return nil, err
+	}
+	stmts := map[int]*sql.Stmt{}
+	defer func() {
+		for _, stmt := range stmts {
+			_ = stmt.Close()
+		}
+		if err != nil {
+			ids = nil
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK TO bulk_insert")
+		}
+		if _, rerr := conn.ExecContext(context.Background(), "RELEASE bulk_insert"); err == nil {
+			err = rerr
+		}
+	}()
+
+	args := make([]any, 0, chunk*len(columns))
+	flush := func() error {
+		n := len(args) / len(columns)
+		stmt, ok := stmts[n]
+		if !ok {
+			var err error
+			stmt, err = conn.PrepareContext(ctx, bulkInsertQuery(table, columns, n))
+			if err != nil {
+				return err
+			}
+			stmts[n] = stmt
+		}
+		var maxRowid int64
+		if given < 0 {
+			if err := conn.QueryRowContext(ctx, "SELECT coalesce(max(rowid), 0) FROM "+quoteIdent(table)).Scan(&maxRowid); err != nil {
+				return err
+			}
+		}
+		r, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+		if inserted, err := r.RowsAffected(); err != nil {
+			return err
+		} else if inserted != int64(n) {
+			return fmt.Errorf("sqlite3: bulk insert: a conflict resolution skipped %d of %d rows", int64(n)-inserted, n)
+		}
+		if given >= 0 {
+			for i := given; i < len(args); i += len(columns) {
+				id, ok := args[i].(int64)
+				if !ok {
+					return fmt.Errorf("sqlite3: bulk insert row %d has a %T rowid, expecting an integer", len(ids), args[i])
+				}
+				ids = append(ids, id)
+			}
+		} else {
+			last, err := r.LastInsertId()
+			if err != nil {
+				return err
+			}
+			// The rows after the largest rowid are the ones of the
+			// statement only if their rowids were assigned in a row.
+			var count, first, end int64
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(*), coalesce(min(rowid), 0), coalesce(max(rowid), 0) FROM "+quoteIdent(table)+" WHERE rowid > ?", maxRowid).Scan(&count, &first, &end)
+			if err != nil {
+				return err
+			}
+			if count != int64(n) || first != last-int64(n)+1 || end != last {
+				return errors.New("sqlite3: bulk insert: the rowids of the rows inserted are not consecutive")
+			}
+			for id := first; id <= last; id++ {
+				ids = append(ids, id)
+			}
+		}
+		args = args[:0]
+		return nil
+	}
+	for row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("sqlite3: bulk insert row %d has %d values for %d columns", len(ids)+len(args)/len(columns), len(row), len(columns))
+		}
+		if given >= 0 {
+			// The rowids are read back from the arguments, converted the
+			// way database/sql converts them.
+			v, err := driver.DefaultParameterConverter.ConvertValue(row[given])
+			if err != nil {
+				return nil, err
+			}
+			row = slices.Clone(row)
+			row[given] = v
+		}
+		args = append(args, row...)
+		if len(args) == cap(args) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(args) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// rowidColumn returns the INTEGER PRIMARY KEY column of table, aliasing its
+// rowid, if any. It fails if table is a WITHOUT ROWID table.
+func rowidColumn(ctx context.Context, conn *sql.Conn, table string) (string, error) {
+	var withoutRowID bool
+	err := conn.QueryRowContext(ctx, `SELECT wr FROM pragma_table_list WHERE schema = 'main' AND name = ? COLLATE NOCASE`, table).Scan(&withoutRowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil // the INSERT reports the missing table
+	}
+	if err != nil {
+		return "", err
+	}
+	if withoutRowID {
+		return "", fmt.Errorf("sqlite3: bulk insert into WITHOUT ROWID table %q, which has no rowids", table)
+	}
+	var col string
+	err = conn.QueryRowContext(ctx, `SELECT name FROM pragma_table_info(?1) WHERE pk = 1 AND upper(type) = 'INTEGER' AND (SELECT COUNT(*) FROM pragma_table_info(?1) WHERE pk > 0) = 1`, table).Scan(&col)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return col, err
+}
+
+// bulkInsertQuery returns the INSERT statement of n rows into the columns of
+// table.
+func bulkInsertQuery(table string, columns []string, n int) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO ")
+	b.WriteString(quoteIdent(table))
+	b.WriteString(" (")
+	for i, col := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(quoteIdent(col))
+	}
+	b.WriteString(") VALUES ")
+	row := "(" + strings.Repeat("?, ", len(columns)-1) + "?)"
+	for i := range n {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(row)
+	}
+	return b.String()
+}
+
+// quoteIdent quotes the identifier name for SQL.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
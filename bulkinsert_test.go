@@ -0,0 +1,169 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBulkInsert(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_limit_variable_number=10")
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT, n INTEGER UNIQUE)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 columns and 10 variables make chunks of 3 rows.
+	rows := func(from, to int) func(yield func([]any) bool) {
+		return func(yield func([]any) bool) {
+			for i := from; i < to; i++ {
+				if !yield([]any{100 + i, "row", i}) {
+					return
+				}
+			}
+		}
+	}
+	ids, err := BulkInsert(ctx, conn, "test", []string{"id", "name", "n"}, rows(0, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{100, 101, 102, 103, 104, 105, 106, 107}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("expected rowids %v, but got %v", want, ids)
+	}
+	var prepares int
+	for _, q := range tracer.queries() {
+		if q == bulkInsertQuery("test", []string{"id", "name", "n"}, 3) || q == bulkInsertQuery("test", []string{"id", "name", "n"}, 2) {
+			prepares++
+		}
+	}
+	// 2 prepares, 2 runs of the 3-row INSERT and 1 of the 2-row one.
+	if prepares != 5 {
+		t.Fatalf("expected 5 traced INSERT statements, but got %d", prepares)
+	}
+
+	// A failing chunk rolls back the whole insert.
+	if _, err := BulkInsert(ctx, conn, "test", []string{"name", "n"}, rows(6, 12)); err == nil {
+		t.Fatal("expected a duplicate n to fail")
+	}
+	if _, err := BulkInsert(ctx, conn, "test", []string{"name", "n"}, slices.Values([][]any{{"short"}})); err == nil {
+		t.Fatal("expected a row of the wrong length to fail")
+	}
+	var count int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 8 {
+		t.Fatalf("expected 8 rows, but got %d", count)
+	}
+
+	// Inside a transaction, the rows are inserted with it.
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err = BulkInsert(ctx, conn, "test", []string{"name"}, slices.Values([][]any{{"a"}, {"b"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []int64{108, 109}) {
+		t.Fatalf("expected rowids [108 109], but got %v", ids)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 8 {
+		t.Fatalf("expected the rolled back rows to be gone, but got %d rows", count)
+	}
+
+	// WITHOUT ROWID tables have no rowids to return.
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE tags (name TEXT PRIMARY KEY) WITHOUT ROWID`); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"tags", "TAGS"} {
+		_, err = BulkInsert(ctx, conn, table, []string{"name"}, slices.Values([][]any{{"a"}}))
+		if err == nil || !strings.Contains(err.Error(), "WITHOUT ROWID") {
+			t.Fatalf("expected a WITHOUT ROWID error for %s, but got %v", table, err)
+		}
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM tags`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no row inserted, but got %d", count)
+	}
+}
+
+func TestBulkInsertRowids(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT UNIQUE ON CONFLICT IGNORE)`,
+		`CREATE TABLE copies (name TEXT)`,
+		`CREATE TRIGGER copies_insert AFTER INSERT ON copies WHEN new.name <> 'copy' BEGIN INSERT INTO copies VALUES ('copy'); END`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rowids given out of order are returned as given.
+	ids, err := BulkInsert(ctx, conn, "test", []string{"ID", "name"}, slices.Values([][]any{{5, "a"}, {3, "b"}, {int32(9), "c"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{5, 3, 9}; !slices.Equal(ids, want) {
+		t.Fatalf("expected rowids %v, but got %v", want, ids)
+	}
+	ids, err = BulkInsert(ctx, conn, "test", []string{"name"}, slices.Values([][]any{{"d"}, {"e"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{10, 11}; !slices.Equal(ids, want) {
+		t.Fatalf("expected rowids %v, but got %v", want, ids)
+	}
+
+	// A row skipped by ON CONFLICT IGNORE would have no rowid.
+	_, err = BulkInsert(ctx, conn, "test", []string{"name"}, slices.Values([][]any{{"f"}, {"a"}, {"g"}}))
+	if err == nil || !strings.Contains(err.Error(), "skipped 1 of 3 rows") {
+		t.Fatalf("expected a skipped row error, but got %v", err)
+	}
+	// The rows of a trigger inserting into the same table are interleaved.
+	_, err = BulkInsert(ctx, conn, "copies", []string{"name"}, slices.Values([][]any{{"a"}, {"b"}}))
+	if err == nil || !strings.Contains(err.Error(), "not consecutive") {
+		t.Fatalf("expected a rowids error, but got %v", err)
+	}
+	var count int
+	if err := conn.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM test) + (SELECT COUNT(*) FROM copies)`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("expected the failed inserts to be rolled back, but got %d rows", count)
+	}
+}
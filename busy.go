@@ -0,0 +1,105 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"weak"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// busyDelays are the sleeps between retries of a busy database, the same as
+// the ones of the busy handler SQLite installs for PRAGMA busy_timeout.
+var busyDelays = []time.Duration{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
+
+// busyConns are the connections opened by the driver, by sqlite3* handle,
+// for the busy handler and the audit_actor() function. They are weak so that
+// a connection leaked without Close can still be collected, see Open.
+var busyConns = struct {
+	sync.RWMutex
+	m map[uintptr]weak.Pointer[SQLiteConn]
+}{m: map[uintptr]weak.Pointer[SQLiteConn]{}}
+
+// busyConn returns the connection of the sqlite3* handle db, if any.
+func busyConn(db uintptr) *SQLiteConn {
+	busyConns.RLock()
+	defer busyConns.RUnlock()
+	return busyConns.m[db].Value()
+}
+
+// setBusyHandler replaces the busy handler installed by PRAGMA busy_timeout
+// with one that waits just as long but gives up once the context of the
+// statement is done and reports every wait to the BusyTracer and Metrics of
+// the connection.
+func (c *SQLiteConn) setBusyHandler() error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	busyConns.Lock()
+	busyConns.m[h.db] = weak.Make(c)
+	busyConns.Unlock()
+	if rc := sqlite3.Xsqlite3_busy_handler(h.tls, h.db, cFuncPointer(busyHandler), h.db); rc != sqlite3.SQLITE_OK {
+		busyConns.Lock()
+		delete(busyConns.m, h.db)
+		busyConns.Unlock()
+		return fmt.Errorf("sqlite3: installing busy handler: result code %d", rc)
+	}
+	c.busyDB = h.db
+	return nil
+}
+
+// clearBusyHandler forgets the busy handler of a connection being closed.
+func (c *SQLiteConn) clearBusyHandler() {
+	if c.busyDB == 0 {
+		return
+	}
+	busyConns.Lock()
+	delete(busyConns.m, c.busyDB)
+	busyConns.Unlock()
+	c.busyDB = 0
+}
+
+func busyHandler(tls *libc.TLS, db uintptr, count int32) int32 {
+	c := busyConn(db)
+	if c == nil {
+		return 0
+	}
+
+	timeout := time.Duration(c.busyTimeout) * time.Millisecond
+	var delay, prior time.Duration
+	if int(count) < len(busyDelays) {
+		delay = busyDelays[count] * time.Millisecond
+		for _, d := range busyDelays[:count] {
+			prior += d * time.Millisecond
+		}
+	} else {
+		delay = busyDelays[len(busyDelays)-1] * time.Millisecond
+		for _, d := range busyDelays {
+			prior += d * time.Millisecond
+		}
+		prior += delay * time.Duration(int(count)-len(busyDelays))
+	}
+	if prior+delay > timeout {
+		if delay = timeout - prior; delay <= 0 {
+			return 0
+		}
+	}
+	if ctx := c.runCtx; ctx != nil {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return 0
+		}
+	} else {
+		time.Sleep(delay)
+	}
+
+	c.busyWait(int(count)+1, delay)
+	return 1
+}
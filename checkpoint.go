@@ -0,0 +1,154 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrCheckpointBusy is returned by Checkpoint when a FULL, RESTART or
+// TRUNCATE checkpoint could not complete because of other readers or
+// writers.
+var ErrCheckpointBusy = errors.New("sqlite3: checkpoint busy")
+
+// CheckpointMode is the mode of a WAL checkpoint, see
+// https://sqlite.org/c3ref/wal_checkpoint_v2.html.
+type CheckpointMode int
+
+const (
+	// CheckpointPassive checkpoints as many frames as possible without
+	// waiting for readers or writers.
+	CheckpointPassive CheckpointMode = iota
+	// CheckpointFull waits for writers, then checkpoints every frame.
+	CheckpointFull
+	// CheckpointRestart is CheckpointFull, then waits for readers so that
+	// the next writer starts the WAL over.
+	CheckpointRestart
+	// CheckpointTruncate is CheckpointRestart, then truncates the WAL file
+	// to zero bytes.
+	CheckpointTruncate
+)
+
+func (m CheckpointMode) String() string {
+	switch m {
+	case CheckpointPassive:
+		return "PASSIVE"
+	case CheckpointFull:
+		return "FULL"
+	case CheckpointRestart:
+		return "RESTART"
+	case CheckpointTruncate:
+		return "TRUNCATE"
+	}
+	return fmt.Sprintf("CheckpointMode(%d)", int(m))
+}
+
+// Checkpoint copies the content of the WAL of the main database of db into
+// the database file with PRAGMA wal_checkpoint(mode). It returns the number
+// of frames in the WAL and the number of them checkpointed, both -1 when
+// the database is not in WAL mode. Modes other than CheckpointPassive wait
+// for the busy timeout, then fail with ErrCheckpointBusy along with the
+// frame counts.
+func Checkpoint(ctx context.Context, db *sql.DB, mode CheckpointMode) (log, checkpointed int, err error) {
+	if mode < CheckpointPassive || mode > CheckpointTruncate {
+		return 0, 0, fmt.Errorf("sqlite3: invalid checkpoint mode %v", mode)
+	}
+	var busy bool
+	err = db.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+mode.String()+")").Scan(&busy, &log, &checkpointed)
+	if err == nil && busy {
+		err = ErrCheckpointBusy
+	}
+	return log, checkpointed, err
+}
+
+// CheckpointerConfig configures a Checkpointer.
+type CheckpointerConfig struct {
+	// MaxWALSize is the size of the WAL file, in bytes, above which a
+	// checkpoint runs, 4 MiB if <= 0.
+	MaxWALSize int64
+	// Interval is how often the size of the WAL file is checked, every
+	// second if <= 0.
+	Interval time.Duration
+	// Mode is the mode of the checkpoints. Only CheckpointTruncate shrinks
+	// the WAL file; with the other modes, SQLite writes it over from the
+	// start, but a file grown above MaxWALSize makes every check run a
+	// checkpoint.
+	Mode CheckpointMode
+	// Logger receives the checkpoints failing, slog.Default() if nil.
+	Logger *slog.Logger
+}
+
+// Checkpointer checkpoints a database in the background when its WAL file
+// grows too large, which the automatic checkpoints of SQLite fail to
+// prevent while long-lived readers keep them from completing.
+type Checkpointer struct {
+	db     *sql.DB
+	cfg    CheckpointerConfig
+	wal    string // path of the WAL file
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewCheckpointer starts a Checkpointer for the main database of db, which
+// must be a file.
+func NewCheckpointer(ctx context.Context, db *sql.DB, cfg CheckpointerConfig) (*Checkpointer, error) {
+	if cfg.MaxWALSize <= 0 {
+		cfg.MaxWALSize = 4 << 20
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	var path string
+	if err := db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("sqlite3: checkpointer needs a database file")
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	c := &Checkpointer{db: db, cfg: cfg, wal: path + "-wal", cancel: cancel, done: make(chan struct{})}
+	go c.run(runCtx)
+	return c, nil
+}
+
+// Close stops the checkpointer, interrupting a running checkpoint.
+func (c *Checkpointer) Close() error {
+	c.once.Do(c.cancel)
+	<-c.done
+	return nil
+}
+
+func (c *Checkpointer) run(ctx context.Context) {
+	defer close(c.done)
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(c.wal)
+		if errors.Is(err, fs.ErrNotExist) || err == nil && fi.Size() <= c.cfg.MaxWALSize {
+			continue
+		}
+		if err == nil {
+			_, _, err = Checkpoint(ctx, c.db, c.cfg.Mode)
+		}
+		if err != nil && ctx.Err() == nil {
+			c.cfg.Logger.LogAttrs(ctx, slog.LevelWarn, "checkpoint failed",
+				slog.String("wal", c.wal), slog.String("mode", c.cfg.Mode.String()), slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:"+file+"?_journal=WAL&_busy_timeout=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO test (name) VALUES ('foo'), ('bar')`); err != nil {
+		t.Fatal(err)
+	}
+
+	log, checkpointed, err := Checkpoint(ctx, db, CheckpointPassive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log <= 0 || checkpointed != log {
+		t.Fatalf("expected every frame checkpointed, but got %d of %d", checkpointed, log)
+	}
+	if _, _, err := Checkpoint(ctx, db, CheckpointTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(file + "-wal"); err != nil || fi.Size() != 0 {
+		t.Fatalf("expected an empty WAL file, but got %v, %v", fi, err)
+	}
+
+	// A reader holding an older snapshot keeps RESTART and TRUNCATE busy.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO test (name) VALUES ('baz')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Checkpoint(ctx, db, CheckpointRestart); !errors.Is(err, ErrCheckpointBusy) {
+		t.Fatalf("expected ErrCheckpointBusy, but got %v", err)
+	}
+	tx.Rollback()
+	if _, _, err := Checkpoint(ctx, db, CheckpointRestart); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Checkpoint(ctx, db, CheckpointMode(4)); err == nil {
+		t.Fatal("expected an invalid mode to fail")
+	}
+
+	mem, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if log, checkpointed, err := Checkpoint(ctx, mem, CheckpointFull); err != nil || log != -1 || checkpointed != -1 {
+		t.Fatalf("expected -1 frames without WAL, but got %d, %d, %v", log, checkpointed, err)
+	}
+}
+
+func TestCheckpointer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:"+file+"?_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO test (name) VALUES ('foo'), ('bar')`); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCheckpointer(ctx, db, CheckpointerConfig{MaxWALSize: 1, Interval: 10 * time.Millisecond, Mode: CheckpointTruncate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fi, err := os.Stat(file + "-wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the WAL file to be truncated, but got %d bytes", fi.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mem, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if _, err := NewCheckpointer(ctx, mem, CheckpointerConfig{}); err == nil {
+		t.Fatal("expected a checkpointer of an in-memory database to fail")
+	}
+}
+
+func TestPersistWAL(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, persist := range []bool{false, true} {
+		file := filepath.Join(dir, "test.db")
+		dsn := "file:" + file + "?_journal=WAL"
+		if persist {
+			file = filepath.Join(dir, "persist.db")
+			dsn = "file:" + file + "?_journal=WAL&_persist_wal=1"
+		}
+		db, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `CREATE TABLE test (name TEXT)`); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(file + "-wal")
+		if persist && err != nil {
+			t.Fatalf("expected the WAL file to persist, but got %v", err)
+		}
+		if !persist && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected the WAL file to be deleted, but got %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, "bad.db")+"?_persist_wal=maybe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err == nil {
+		t.Fatal("expected an invalid _persist_wal to fail")
+	}
+}
@@ -4,6 +4,14 @@ package sqlite3
 import (
 	"context"
 	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"modernc.org/sqlite"
 )
@@ -27,4 +35,577 @@ type sqliteConn interface {
 	Deserialize(buf []byte) (err error)
 	NewBackup(dstUri string) (*sqlite.Backup, error)
 	NewRestore(srcUri string) (*sqlite.Backup, error)
+	Status(op sqlite.DBStatusOp, reset bool) (current, high int, err error)
+}
+
+// SQLiteConn is the connection returned by the driver. It wraps the
+// modernc.org/sqlite connection and runs the hooks configured on the Connector
+// that opened it around every statement.
+type SQLiteConn struct {
+	conn    sqliteConn
+	dsn     string
+	tracer  Tracer
+	metrics *Metrics
+	logger  *slog.Logger
+
+	slowQuery   time.Duration // from _slow_query_ms
+	explainConn *SQLiteConn   // side connection running EXPLAIN QUERY PLAN
+
+	nestedTx     bool     // from _nested_tx=savepoint
+	savepoints   []string // savepoints open for nested transactions, innermost last
+	savepointSeq int      // number of the last savepoint of the transaction
+	txGen        int      // incremented when a transaction BeginTx started ends
+
+	busyTimeout int             // milliseconds, from _busy_timeout
+	busyDB      uintptr         // sqlite3* the busy handler is registered for
+	stmtCtx     context.Context // context of the traced statement running
+	runCtx      context.Context // context of the statement running, see watch
+	progressDB  uintptr         // sqlite3* the progress handler is registered for
+	authDB      uintptr         // sqlite3* the authorizer is registered for
+	preUpdateDB uintptr         // sqlite3* the pre-update hook is registered for
+
+	stmts *stmtCache // from _stmt_cache
+
+	sessions map[*Session]struct{} // open sessions, closed with c
+
+	actor        *string // actor of the statement running, see useActor
+	auditTargets int     // audit tables of connector with an actor trigger
+
+	connector *Connector   // that opened c, if any
+	gen       int64        // generation of the database file of connector, see SwapDatabase
+	pool      atomic.Int32 // connBusy, connIdle or connClosed, see take
+
+	closeMu sync.Mutex               // guards closed and open against SQLiteStmt.Close
+	closed  bool                     // set by Close
+	open    map[*SQLiteStmt]struct{} // statements prepared and not closed, closed with c
+
+	cleanups []runtime.Cleanup // run if c is collected without Close
+}
+
+// Prepare implements driver.Conn.
+func (c *SQLiteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// Close implements driver.Conn. It closes the statements left open on c,
+// which SwapDatabase may close while database/sql still holds them.
+func (c *SQLiteConn) Close() error {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.pool.Store(connClosed)
+	for s := range c.open {
+		_ = s.stmt.Close()
+	}
+	c.open = nil
+	for _, cleanup := range c.cleanups {
+		cleanup.Stop()
+	}
+	c.clearBusyHandler()
+	c.clearProgressHandler()
+	c.clearAuthorizer()
+	c.clearPreUpdateHook()
+	c.closeSessions()
+	if c.metrics != nil {
+		c.metrics.openConns.Add(-1)
+	}
+	if c.explainConn != nil {
+		_ = c.explainConn.Close()
+	}
+	if c.stmts != nil {
+		_ = c.stmts.close()
+	}
+	err := c.conn.Close()
+	if c.connector != nil {
+		c.connector.forget(c)
+	}
+	return err
+}
+
+// leakedConn is what closeLeaked needs of a SQLiteConn, which it must not
+// reference.
+type leakedConn struct {
+	conn sqliteConn
+	db   uintptr // sqlite3* of conn
+}
+
+// closeLeaked closes the connection of a SQLiteConn collected without Close
+// and forgets the callbacks registered for it.
+func closeLeaked(l leakedConn) {
+	busyConns.Lock()
+	delete(busyConns.m, l.db)
+	busyConns.Unlock()
+	progressConns.Lock()
+	delete(progressConns.m, l.db)
+	progressConns.Unlock()
+	authConns.Lock()
+	delete(authConns.m, l.db)
+	authConns.Unlock()
+	preUpdateConns.Lock()
+	delete(preUpdateConns.m, l.db)
+	preUpdateConns.Unlock()
+	_ = l.conn.Close()
+}
+
+// Begin implements driver.Conn.
+func (c *SQLiteConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx.
+//
+// With _nested_tx=savepoint, BeginTx inside a transaction opens the savepoint
+// sp_N instead, N counting the savepoints of the transaction. Its Commit
+// releases the savepoint and its Rollback rolls back to it; opts do not apply
+// to it. Both fail with sql.ErrTxDone once the outer transaction ended, and
+// with an error while a transaction nested in it is still open.
+func (c *SQLiteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	if c.nestedTx && c.inTx() {
+		return c.beginSavepoint(ctx, opts)
+	}
+	if c.savepointSeq > 0 {
+		// The last transaction was ended by a statement.
+		c.endTx()
+	}
+	ctx, txStart := c.txStart(ctx, opts)
+	sctx, st := c.traceStart(ctx, OperationBegin, "BEGIN", nil)
+	stop := c.watch(sctx)
+	t, err := c.conn.BeginTx(sctx, opts)
+	stop()
+	err = ctxErr(sctx, err)
+	c.traceEnd(sctx, st, -1, err)
+	if err != nil {
+		c.txEnd(ctx, txStart, false, err)
+		return nil, err
+	}
+	return &SQLiteTx{c: c, ctx: ctx, start: txStart, tx: t}, nil
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+//
+// With _stmt_cache, the statement is taken from the statement cache of the
+// connection and returned to it when closed.
+func (c *SQLiteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	ctx, st := c.traceStart(ctx, OperationPrepare, query, nil)
+	var s driver.Stmt
+	var err error
+	if c.stmts != nil {
+		s, err = c.cachedPrepare(ctx, query)
+	} else {
+		s, err = c.conn.PrepareContext(ctx, query)
+	}
+	c.traceEnd(ctx, st, -1, err)
+	if err != nil {
+		return nil, err
+	}
+	ss := &SQLiteStmt{c: c, query: query, stmt: s, cached: c.stmts != nil}
+	if !ss.cached {
+		c.closeMu.Lock()
+		if c.open == nil {
+			c.open = map[*SQLiteStmt]struct{}{}
+		}
+		c.open[ss] = struct{}{}
+		c.closeMu.Unlock()
+	}
+	return ss, nil
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *SQLiteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	ctx, st := c.traceStart(ctx, OperationExec, query, args)
+	stop := c.watch(ctx)
+	var r driver.Result
+	var err error
+	restore := c.useActor(ctx)
+	switch {
+	case hasZeroBlob(args):
+		r, err = c.execZeroBlob(ctx, query, args)
+	case c.stmts != nil:
+		var s driver.Stmt
+		if s, err = c.cachedPrepare(ctx, query); err == nil {
+			r, err = s.(driver.StmtExecContext).ExecContext(ctx, args)
+			c.releaseStmt(query, s, err)
+		}
+	default:
+		r, err = c.conn.ExecContext(ctx, query, args)
+	}
+	restore()
+	stop()
+	err = ctxErr(ctx, err)
+	c.traceEnd(ctx, st, rowsAffected(r), err)
+	return r, err
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *SQLiteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	ctx, st := c.traceStart(ctx, OperationQuery, query, args)
+	stop := c.watch(ctx)
+	var r driver.Rows
+	var release func(error)
+	var err error
+	restore := c.useActor(ctx)
+	switch {
+	case hasZeroBlob(args):
+		err = ErrZeroBlobQuery
+	case c.stmts != nil:
+		var s driver.Stmt
+		if s, err = c.cachedPrepare(ctx, query); err == nil {
+			release = func(err error) { c.releaseStmt(query, s, err) }
+			if r, err = s.(driver.StmtQueryContext).QueryContext(ctx, args); err != nil {
+				release(err)
+				release = nil
+			}
+		}
+	default:
+		r, err = c.conn.QueryContext(ctx, query, args)
+	}
+	// The changes of a query, e.g. INSERT ... RETURNING, are all made by
+	// its first step.
+	restore()
+	if err != nil {
+		stop()
+		err = ctxErr(ctx, err)
+	}
+	c.traceEnd(ctx, st, -1, err)
+	if err != nil {
+		return nil, err
+	}
+	return c.traceRows(ctx, st, r, stop, release), nil
+}
+
+// Ping implements driver.Pinger.
+func (c *SQLiteConn) Ping(ctx context.Context) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.conn.Ping(ctx)
+}
+
+// ResetSession implements driver.SessionResetter.
+func (c *SQLiteConn) ResetSession(ctx context.Context) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+	if err := c.conn.ResetSession(ctx); err != nil {
+		return err
+	}
+	return c.installActorTriggers(ctx)
+}
+
+// IsValid implements driver.Validator.
+func (c *SQLiteConn) IsValid() bool {
+	if !c.conn.IsValid() {
+		return false
+	}
+	// database/sql calls IsValid as it returns c to the pool. c is marked
+	// idle before checking whether it is stale, so that SwapDatabase either
+	// closes it or sees it rejected.
+	c.idle()
+	return !c.stale()
+}
+
+// JournalMode returns the journal mode of the main database, e.g. "wal" or "delete".
+func (c *SQLiteConn) JournalMode() (string, error) {
+	var mode string
+	err := c.queryRow(context.Background(), "PRAGMA journal_mode", &mode)
+	return mode, err
+}
+
+// FileControlPersistWAL sets or queries SQLITE_FCNTL_PERSIST_WAL, see sqlite.FileControl.
+func (c *SQLiteConn) FileControlPersistWAL(dbName string, mode int) (int, error) {
+	return c.conn.FileControlPersistWAL(dbName, mode)
+}
+
+// Serialize returns the serialization of the main database.
+func (c *SQLiteConn) Serialize() ([]byte, error) {
+	return c.conn.Serialize()
+}
+
+// Deserialize replaces the main database with the serialization in buf.
+func (c *SQLiteConn) Deserialize(buf []byte) error {
+	return c.conn.Deserialize(buf)
+}
+
+// NewBackup starts an online backup of the main database into dstUri.
+func (c *SQLiteConn) NewBackup(dstUri string) (*sqlite.Backup, error) {
+	return c.conn.NewBackup(dstUri)
+}
+
+// NewRestore starts an online restore of srcUri into the main database.
+func (c *SQLiteConn) NewRestore(srcUri string) (*sqlite.Backup, error) {
+	return c.conn.NewRestore(srcUri)
+}
+
+// queryRow runs query untraced and scans the columns of its first row into
+// dest, which holds *string, *int64 or *[]byte values.
+func (c *SQLiteConn) queryRow(ctx context.Context, query string, dest ...any) error {
+	rows, err := c.conn.QueryContext(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(values); err != nil {
+		return err
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *string:
+			switch v := values[i].(type) {
+			case string:
+				*d = v
+			case []byte:
+				*d = string(v)
+			}
+		case *int64:
+			*d, _ = values[i].(int64)
+		case *[]byte:
+			*d, _ = values[i].([]byte)
+		default:
+			return fmt.Errorf("sqlite3: unsupported scan destination %T", d)
+		}
+	}
+	return nil
+}
+
+// SQLiteStmt is the prepared statement returned by SQLiteConn.
+type SQLiteStmt struct {
+	c      *SQLiteConn
+	query  string
+	stmt   driver.Stmt
+	cached bool  // returned to the statement cache when closed
+	err    error // of the last run, for the statement cache
+}
+
+// Close implements driver.Stmt. It does nothing once the connection of s
+// closed, which closed s.
+func (s *SQLiteStmt) Close() error {
+	c := s.c
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	if s.cached {
+		c.releaseStmt(s.query, s.stmt, s.err)
+		return nil
+	}
+	delete(c.open, s)
+	return s.stmt.Close()
+}
+
+// NumInput implements driver.Stmt.
+func (s *SQLiteStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// Exec implements driver.Stmt.
+func (s *SQLiteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+// Query implements driver.Stmt.
+func (s *SQLiteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (s *SQLiteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, st := s.c.traceStart(ctx, OperationExec, s.query, args)
+	stop := s.c.watch(ctx)
+	var r driver.Result
+	var err error
+	restore := s.c.useActor(ctx)
+	switch {
+	case hasZeroBlob(args):
+		r, err = s.c.execZeroBlob(ctx, s.query, args)
+	default:
+		r, err = s.stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+		s.err = err
+	}
+	restore()
+	stop()
+	err = ctxErr(ctx, err)
+	s.c.traceEnd(ctx, st, rowsAffected(r), err)
+	return r, err
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (s *SQLiteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, st := s.c.traceStart(ctx, OperationQuery, s.query, args)
+	stop := s.c.watch(ctx)
+	var r driver.Rows
+	var err error
+	restore := s.c.useActor(ctx)
+	if hasZeroBlob(args) {
+		err = ErrZeroBlobQuery
+	} else {
+		r, err = s.stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+		s.err = err
+	}
+	restore()
+	if err != nil {
+		stop()
+		err = ctxErr(ctx, err)
+	}
+	s.c.traceEnd(ctx, st, -1, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.traceRows(ctx, st, r, stop, nil), nil
+}
+
+// SQLiteTx is the transaction returned by SQLiteConn.
+type SQLiteTx struct {
+	c         *SQLiteConn
+	ctx       context.Context // context the transaction was started with
+	start     time.Time
+	tx        driver.Tx
+	savepoint string // name of the savepoint of a nested transaction
+	gen       int    // txGen of c when the savepoint was opened
+}
+
+// Commit implements driver.Tx.
+func (t *SQLiteTx) Commit() error {
+	if t.savepoint != "" {
+		return t.endSavepoint(true)
+	}
+	t.c.endTx()
+	ctx, st := t.c.traceStart(t.ctx, OperationCommit, "COMMIT", nil)
+	err := t.tx.Commit()
+	t.c.traceEnd(ctx, st, -1, err)
+	t.c.txEnd(t.ctx, t.start, err == nil, err)
+	return err
+}
+
+// Rollback implements driver.Tx.
+func (t *SQLiteTx) Rollback() error {
+	if t.savepoint != "" {
+		return t.endSavepoint(false)
+	}
+	t.c.endTx()
+	ctx, st := t.c.traceStart(t.ctx, OperationRollback, "ROLLBACK", nil)
+	err := t.tx.Rollback()
+	t.c.traceEnd(ctx, st, -1, err)
+	t.c.txEnd(t.ctx, t.start, false, err)
+	return err
+}
+
+// SQLiteRows is the result set returned by SQLiteConn when the query can be
+// cancelled or the iteration of rows is observed by a RowsTracer, Metrics or
+// the slow query log. It reports the iteration when closed.
+type SQLiteRows struct {
+	c       *SQLiteConn
+	rows    driver.Rows
+	ctx     context.Context
+	st      stmtTrace   // of the query
+	stop    func()      // stops the watch of ctx
+	release func(error) // returns the statement to the statement cache, if set
+	start   time.Time
+	n       int64
+	err     error
+	closed  bool
+}
+
+// Columns implements driver.Rows.
+func (r *SQLiteRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+// Close implements driver.Rows.
+func (r *SQLiteRows) Close() error {
+	err := r.rows.Close()
+	if !r.closed {
+		r.closed = true
+		r.stop()
+		if r.err == nil {
+			r.err = err
+		}
+		if r.release != nil {
+			r.release(r.err)
+		}
+		r.c.rowsEnd(r.ctx, r.st, r.start, r.n, r.err)
+	}
+	return err
+}
+
+// Next implements driver.Rows.
+func (r *SQLiteRows) Next(dest []driver.Value) error {
+	err := ctxErr(r.ctx, r.rows.Next(dest))
+	switch {
+	case err == nil:
+		r.n++
+	case err != io.EOF:
+		r.err = err
+	}
+	return err
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName.
+func (r *SQLiteRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength implements driver.RowsColumnTypeLength.
+func (r *SQLiteRows) ColumnTypeLength(index int) (int64, bool) {
+	if rs, ok := r.rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements driver.RowsColumnTypeNullable.
+func (r *SQLiteRows) ColumnTypeNullable(index int) (bool, bool) {
+	if rs, ok := r.rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale implements driver.RowsColumnTypePrecisionScale.
+func (r *SQLiteRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if rs, ok := r.rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// ColumnTypeScanType implements driver.RowsColumnTypeScanType.
+func (r *SQLiteRows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+// namedValues converts positional arguments to the form the context-aware
+// driver interfaces take.
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
 }
@@ -0,0 +1,154 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"weak"
+)
+
+// Connector is a driver.Connector for a fixed DSN. It configures the
+// connections it opens beyond what the DSN can express. Use it with
+// sql.OpenDB, e.g. for ent:
+//
+//	c := sqlite3.NewConnector("file:ent?mode=memory&cache=shared&_fk=1")
+//	c.Tracer = myTracer
+//	drv := entsql.OpenDB(dialect.SQLite, sql.OpenDB(c))
+//	client := ent.NewClient(ent.Driver(drv))
+type Connector struct {
+	// Tracer, when set, observes every statement run on the connections
+	// opened by the connector.
+	Tracer Tracer
+
+	// Metrics, when set, collects statistics about the connections opened
+	// by the connector.
+	Metrics *Metrics
+
+	// ProgressHandler, when set, is the progress handler of the
+	// connections opened by the connector, called every ProgressOps
+	// virtual machine instructions, 1000 if not set. See
+	// SQLiteConn.SetProgressHandler.
+	ProgressHandler func() bool
+	ProgressOps     int
+
+	// Authorizer, when set, is the authorizer of the connections opened by
+	// the connector, see SQLiteConn.SetAuthorizer.
+	Authorizer func(action int, arg1, arg2, db, trigger string) AuthResult
+
+	// PreUpdateHook, when set, is the pre-update hook of the connections
+	// opened by the connector, see SQLiteConn.RegisterPreUpdateHook.
+	PreUpdateHook func(PreUpdateData)
+
+	// Logger receives the records of the slow query log enabled by
+	// _slow_query_ms. slog.Default() is used when nil.
+	Logger *slog.Logger
+
+	driver *SQLiteDriver
+	dsn    string
+
+	// swap is held by SwapDatabase while it replaces the database file and
+	// by Connect while it opens a connection.
+	swap    sync.RWMutex
+	gen     atomic.Int64                       // generation of the database file
+	mu      sync.Mutex                         // guards conns and auditTargets
+	conns   map[weak.Pointer[SQLiteConn]]int64 // open connections, to their gen
+	drained chan struct{}                      // signaled when a connection closes
+
+	auditTargets []string // audit tables of EnableAudit
+}
+
+// NewConnector returns a Connector for dsn, which takes the same parameters
+// as sql.Open("sqlite3", dsn).
+func NewConnector(dsn string) *Connector {
+	return &Connector{driver: &SQLiteDriver{}, dsn: dsn}
+}
+
+// NewSandboxConnector returns a Connector for running user-supplied SQL on
+// dsn: its connections are query-only and authorized by
+// ReadOnlyAuthorizer(tables).
+func NewSandboxConnector(dsn string, tables map[string][]string) *Connector {
+	if strings.ContainsRune(dsn, '?') {
+		dsn += "&_query_only=1"
+	} else {
+		dsn += "?_query_only=1"
+	}
+	c := NewConnector(dsn)
+	c.Authorizer = ReadOnlyAuthorizer(tables)
+	return c
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *SQLiteDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return &Connector{driver: d, dsn: dsn}, nil
+}
+
+// Connect implements driver.Connector. It waits for a running
+// SwapDatabase to complete.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.swap.RLock()
+	defer c.swap.RUnlock()
+	start := time.Now()
+	conn, err := c.connect()
+	if t, ok := c.Tracer.(ConnectTracer); ok {
+		sc, _ := conn.(*SQLiteConn)
+		t.OnConnect(ctx, sc, time.Since(start), err)
+	}
+	return conn, err
+}
+
+// connect opens a connection and applies the configuration of the connector
+// on top of the one Open does from the DSN.
+func (c *Connector) connect() (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := conn.(*SQLiteConn)
+	if !ok {
+		return conn, nil
+	}
+	sc.tracer = c.Tracer
+	sc.metrics = c.Metrics
+	sc.logger = c.Logger
+	c.track(sc)
+	if c.Metrics != nil {
+		c.Metrics.connect(sc)
+	}
+	if c.ProgressHandler != nil {
+		ops := c.ProgressOps
+		if ops < 1 {
+			ops = 1000
+		}
+		if err := sc.SetProgressHandler(ops, c.ProgressHandler); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+	}
+	if c.Authorizer != nil {
+		if err := sc.SetAuthorizer(c.Authorizer); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+	}
+	if c.PreUpdateHook != nil {
+		if err := sc.RegisterPreUpdateHook(c.PreUpdateHook); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+	}
+	if err := sc.installActorTriggers(context.Background()); err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Driver implements driver.Connector.
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,261 @@
+package entdriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql/schema"
+	"entgo.io/ent/schema/field"
+	"github.com/sqlite3ent/sqlite3"
+)
+
+// DriftKind is the kind of a Drift.
+type DriftKind string
+
+const (
+	DriftMissingTable      DriftKind = "missing table"
+	DriftMissingColumn     DriftKind = "missing column"
+	DriftColumnType        DriftKind = "column type"
+	DriftMissingIndex      DriftKind = "missing index"
+	DriftIndex             DriftKind = "index"
+	DriftMissingForeignKey DriftKind = "missing foreign key"
+	DriftForeignKey        DriftKind = "foreign key"
+)
+
+// Drift is a difference between a table ent expects and the live database.
+type Drift struct {
+	Kind  DriftKind
+	Table string
+	// Name is the name of the column, index or foreign key, if any.
+	Name string
+	// Want and Got describe the expected and the live definition, if any.
+	Want, Got string
+}
+
+func (d Drift) String() string {
+	s := fmt.Sprintf("%s %s", d.Kind, d.Table)
+	if d.Name != "" {
+		s += "." + d.Name
+	}
+	switch {
+	case d.Got != "":
+		s += fmt.Sprintf(": want %s, got %s", d.Want, d.Got)
+	case d.Want != "":
+		s += ": want " + d.Want
+	}
+	return s
+}
+
+// DriftError is returned by CheckSchema when the database differs from the
+// tables ent expects.
+type DriftError struct {
+	Drifts []Drift
+}
+
+func (e *DriftError) Error() string {
+	s := make([]string, len(e.Drifts))
+	for i, d := range e.Drifts {
+		s[i] = d.String()
+	}
+	return "entdriver: schema drift: " + strings.Join(s, "; ")
+}
+
+// CheckSchema returns a *DriftError if the schema of db differs from
+// tables, see Diff. It suits health checks failing fast on a database
+// edited by hand.
+func CheckSchema(ctx context.Context, db *sql.DB, tables []*schema.Table) error {
+	drifts, err := Diff(ctx, db, tables)
+	if err != nil {
+		return err
+	}
+	if len(drifts) > 0 {
+		return &DriftError{Drifts: drifts}
+	}
+	return nil
+}
+
+// Diff compares tables, e.g. the migrate.Tables generated by ent, with the
+// schema of db read by sqlite3.Inspect. It reports the tables, columns,
+// indexes and foreign keys of tables missing from db, the columns whose
+// declared type is not the one ent migrations create, and the indexes and
+// foreign keys that differ. What db has beyond tables is not reported.
+func Diff(ctx context.Context, db *sql.DB, tables []*schema.Table) ([]Drift, error) {
+	live, err := sqlite3.Inspect(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var drifts []Drift
+	for _, t := range tables {
+		lt := live.Table(t.Name)
+		if lt == nil {
+			drifts = append(drifts, Drift{Kind: DriftMissingTable, Table: t.Name})
+			continue
+		}
+		drifts = append(drifts, diffColumns(t, lt)...)
+		drifts = append(drifts, diffIndexes(t, lt)...)
+		drifts = append(drifts, diffForeignKeys(t, lt, live)...)
+	}
+	return drifts, nil
+}
+
+func diffColumns(t *schema.Table, lt *sqlite3.Table) []Drift {
+	var drifts []Drift
+	for _, c := range t.Columns {
+		lc := lt.Column(c.Name)
+		if lc == nil {
+			drifts = append(drifts, Drift{Kind: DriftMissingColumn, Table: t.Name, Name: c.Name})
+			continue
+		}
+		if want := columnType(c); want != "" && !strings.EqualFold(strings.TrimSpace(lc.Type), want) {
+			drifts = append(drifts, Drift{Kind: DriftColumnType, Table: t.Name, Name: c.Name, Want: want, Got: lc.Type})
+		}
+	}
+	return drifts
+}
+
+func diffIndexes(t *schema.Table, lt *sqlite3.Table) []Drift {
+	var drifts []Drift
+	for _, idx := range t.Indexes {
+		want := indexDef(idx.Unique, columnNames(idx.Columns))
+		li := lt.Index(idx.Name)
+		if li == nil {
+			drifts = append(drifts, Drift{Kind: DriftMissingIndex, Table: t.Name, Name: idx.Name, Want: want})
+			continue
+		}
+		if got := indexDef(li.Unique, keyColumns(li)); got != want {
+			drifts = append(drifts, Drift{Kind: DriftIndex, Table: t.Name, Name: idx.Name, Want: want, Got: got})
+		}
+	}
+	// A UNIQUE column is backed by the index <table>_<column>_key of ent
+	// migrations, or by the automatic index of a UNIQUE constraint.
+	for _, c := range t.Columns {
+		if !c.Unique || slices.ContainsFunc(lt.Indexes, func(li *sqlite3.Index) bool {
+			return li.Unique && !li.Partial && slices.Equal(keyColumns(li), []string{c.Name})
+		}) {
+			continue
+		}
+		drifts = append(drifts, Drift{Kind: DriftMissingIndex, Table: t.Name, Name: t.Name + "_" + c.Name + "_key", Want: indexDef(true, []string{c.Name})})
+	}
+	return drifts
+}
+
+func diffForeignKeys(t *schema.Table, lt *sqlite3.Table, live *sqlite3.Schema) []Drift {
+	var drifts []Drift
+	for _, fk := range t.ForeignKeys {
+		cols := columnNames(fk.Columns)
+		name := fk.Symbol
+		if name == "" {
+			name = strings.Join(cols, ",")
+		}
+		refTable := ""
+		if fk.RefTable != nil {
+			refTable = fk.RefTable.Name
+		}
+		want := fkDef(refTable, columnNames(fk.RefColumns), string(fk.OnUpdate), string(fk.OnDelete))
+		i := slices.IndexFunc(lt.ForeignKeys, func(lfk *sqlite3.ForeignKey) bool {
+			return slices.Equal(lfk.Columns, cols)
+		})
+		if i < 0 {
+			drifts = append(drifts, Drift{Kind: DriftMissingForeignKey, Table: t.Name, Name: name, Want: want})
+			continue
+		}
+		lfk := lt.ForeignKeys[i]
+		if got := fkDef(lfk.RefTable, refColumns(lfk, live), lfk.OnUpdate, lfk.OnDelete); !strings.EqualFold(got, want) {
+			drifts = append(drifts, Drift{Kind: DriftForeignKey, Table: t.Name, Name: name, Want: want, Got: got})
+		}
+	}
+	return drifts
+}
+
+// columnType returns the declared type ent migrations give c, or "" if it is
+// not known.
+func columnType(c *schema.Column) string {
+	if t := c.SchemaType[dialect.SQLite]; t != "" {
+		return strings.ToLower(t)
+	}
+	switch c.Type {
+	case field.TypeBool:
+		return "bool"
+	case field.TypeInt8, field.TypeUint8, field.TypeInt16, field.TypeUint16, field.TypeInt32,
+		field.TypeUint32, field.TypeUint, field.TypeInt, field.TypeInt64, field.TypeUint64:
+		return "integer"
+	case field.TypeBytes:
+		return "blob"
+	case field.TypeString, field.TypeEnum:
+		return "text"
+	case field.TypeFloat32, field.TypeFloat64:
+		return "real"
+	case field.TypeTime:
+		return "datetime"
+	case field.TypeJSON:
+		return "json"
+	case field.TypeUUID:
+		return "uuid"
+	}
+	return ""
+}
+
+func columnNames(cols []*schema.Column) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// keyColumns returns the names of the key columns of idx.
+func keyColumns(idx *sqlite3.Index) []string {
+	var names []string
+	for _, c := range idx.Columns {
+		if c.Key {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// refColumns returns the columns fk references, resolving the implicit
+// primary key of its table.
+func refColumns(fk *sqlite3.ForeignKey, live *sqlite3.Schema) []string {
+	if !slices.Contains(fk.RefColumns, "") {
+		return fk.RefColumns
+	}
+	t := live.Table(fk.RefTable)
+	if t == nil {
+		return fk.RefColumns
+	}
+	var pk []*sqlite3.Column
+	for _, c := range t.Columns {
+		if c.PrimaryKey > 0 {
+			pk = append(pk, c)
+		}
+	}
+	slices.SortFunc(pk, func(a, b *sqlite3.Column) int { return a.PrimaryKey - b.PrimaryKey })
+	names := make([]string, len(pk))
+	for i, c := range pk {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func indexDef(unique bool, cols []string) string {
+	s := "INDEX (" + strings.Join(cols, ", ") + ")"
+	if unique {
+		s = "UNIQUE " + s
+	}
+	return s
+}
+
+func fkDef(refTable string, refCols []string, onUpdate, onDelete string) string {
+	if onUpdate == "" {
+		onUpdate = string(schema.NoAction)
+	}
+	if onDelete == "" {
+		onDelete = string(schema.NoAction)
+	}
+	return fmt.Sprintf("REFERENCES %s (%s) ON UPDATE %s ON DELETE %s", refTable, strings.Join(refCols, ", "), onUpdate, onDelete)
+}
@@ -0,0 +1,114 @@
+package entdriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/schema"
+	"entgo.io/ent/schema/field"
+)
+
+func driftTables() []*schema.Table {
+	usersColumns := []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "email", Type: field.TypeString, Unique: true},
+		{Name: "age", Type: field.TypeInt},
+		{Name: "created_at", Type: field.TypeTime},
+	}
+	users := &schema.Table{
+		Name:       "users",
+		Columns:    usersColumns,
+		PrimaryKey: []*schema.Column{usersColumns[0]},
+		Indexes: []*schema.Index{
+			{Name: "user_age", Columns: []*schema.Column{usersColumns[2]}},
+		},
+	}
+	petsColumns := []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "name", Type: field.TypeString},
+		{Name: "user_pets", Type: field.TypeInt, Nullable: true},
+	}
+	pets := &schema.Table{
+		Name:       "pets",
+		Columns:    petsColumns,
+		PrimaryKey: []*schema.Column{petsColumns[0]},
+		ForeignKeys: []*schema.ForeignKey{
+			{
+				Symbol:     "pets_users_pets",
+				Columns:    []*schema.Column{petsColumns[2]},
+				RefColumns: []*schema.Column{usersColumns[0]},
+				RefTable:   users,
+				OnDelete:   schema.SetNull,
+			},
+		},
+	}
+	return []*schema.Table{users, pets}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	tables := driftTables()
+
+	// A database migrated by ent has no drift.
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "ent.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := schema.NewMigrate(entsql.OpenDB(dialect.SQLite, db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Create(ctx, tables...); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(ctx, db, tables); err != nil {
+		t.Fatal(err)
+	}
+
+	// A database edited by hand does.
+	edited, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "edited.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edited.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE users (id integer PRIMARY KEY AUTOINCREMENT, email text NOT NULL, age text NOT NULL)`,
+		`CREATE UNIQUE INDEX user_age ON users (age)`,
+		`CREATE TABLE pets (id integer PRIMARY KEY AUTOINCREMENT, name text NOT NULL, user_pets integer NULL REFERENCES users ON DELETE CASCADE)`,
+	} {
+		if _, err := edited.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drifts, err := Diff(ctx, edited, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Drift{
+		{Kind: DriftColumnType, Table: "users", Name: "age", Want: "integer", Got: "TEXT"},
+		{Kind: DriftMissingColumn, Table: "users", Name: "created_at"},
+		{Kind: DriftIndex, Table: "users", Name: "user_age", Want: "INDEX (age)", Got: "UNIQUE INDEX (age)"},
+		{Kind: DriftMissingIndex, Table: "users", Name: "users_email_key", Want: "UNIQUE INDEX (email)"},
+		{Kind: DriftForeignKey, Table: "pets", Name: "pets_users_pets",
+			Want: "REFERENCES users (id) ON UPDATE NO ACTION ON DELETE SET NULL",
+			Got:  "REFERENCES users (id) ON UPDATE NO ACTION ON DELETE CASCADE"},
+	}
+	if !reflect.DeepEqual(drifts, want) {
+		t.Fatalf("expected drifts %v, but got %v", want, drifts)
+	}
+
+	if _, err := edited.ExecContext(ctx, `DROP TABLE pets`); err != nil {
+		t.Fatal(err)
+	}
+	var derr *DriftError
+	if err := CheckSchema(ctx, edited, tables); !errors.As(err, &derr) || derr.Drifts[len(derr.Drifts)-1].Kind != DriftMissingTable {
+		t.Fatalf("expected a DriftError ending with the missing table, but got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package entdriver provides ent drivers built on the sqlite3 driver.
+//
+// OpenPool splits the connections to a database into a single writer and a
+// pool of read-only readers, so that ent transactions queue for the write
+// lock in Go instead of failing with SQLITE_BUSY:
+//
+//	pool, err := entdriver.OpenPool(entdriver.Config{DSN: "file:ent.db?_fk=1"})
+//	if err != nil {
+//		return err
+//	}
+//	client := ent.NewClient(ent.Driver(pool))
+//
+// CheckSchema and Diff compare the tables ent generates with the live
+// schema of a database.
+package entdriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"runtime"
+	"strings"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/sqlite3ent/sqlite3"
+)
+
+// Config configures OpenPool.
+type Config struct {
+	// DSN is the data source name of the database, with the parameters
+	// sql.Open("sqlite3", DSN) takes. Unless it sets _journal, the
+	// database is switched to WAL mode, which lets the readers run
+	// alongside the writer.
+	DSN string
+
+	// Readers is the number of read-only connections. It defaults to
+	// runtime.NumCPU().
+	Readers int
+
+	// Configure, when set, is called with the connectors of the writer and
+	// of the readers before they open any connection, e.g. to set a Tracer.
+	Configure func(c *sqlite3.Connector, readOnly bool)
+}
+
+// Pool is an ent dialect.Driver over a single writer connection and a pool
+// of read-only connections to the same database.
+//
+// Exec, Tx and every statement but SELECT go to the writer; SELECT queries
+// and read-only transactions started with BeginTx go to the readers.
+type Pool struct {
+	writer *entsql.Driver
+	reader *entsql.Driver
+}
+
+var _ dialect.Driver = (*Pool)(nil)
+
+// OpenPool opens the writer and reader pools described by cfg.
+func OpenPool(cfg Config) (*Pool, error) {
+	if cfg.DSN == "" {
+		return nil, errors.New("entdriver: empty DSN")
+	}
+	readers := cfg.Readers
+	if readers <= 0 {
+		readers = runtime.NumCPU()
+	}
+	dsn, err := withParam(cfg.DSN, "_journal", "WAL", "_journal", "_journal_mode")
+	if err != nil {
+		return nil, err
+	}
+	readerDSN, err := withParam(dsn, "_query_only", "1", "_query_only")
+	if err != nil {
+		return nil, err
+	}
+
+	wc := sqlite3.NewConnector(dsn)
+	rc := sqlite3.NewConnector(readerDSN)
+	if cfg.Configure != nil {
+		cfg.Configure(wc, false)
+		cfg.Configure(rc, true)
+	}
+
+	w := sql.OpenDB(wc)
+	w.SetMaxOpenConns(1)
+	// Open the writer first so that it sets the journal mode alone.
+	if err := w.Ping(); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	r := sql.OpenDB(rc)
+	r.SetMaxOpenConns(readers)
+	r.SetMaxIdleConns(readers)
+
+	return &Pool{
+		writer: entsql.OpenDB(dialect.SQLite, w),
+		reader: entsql.OpenDB(dialect.SQLite, r),
+	}, nil
+}
+
+// Writer returns the single-connection pool of the writer.
+func (p *Pool) Writer() *sql.DB {
+	return p.writer.DB()
+}
+
+// Reader returns the pool of the read-only connections.
+func (p *Pool) Reader() *sql.DB {
+	return p.reader.DB()
+}
+
+// Exec implements dialect.ExecQuerier. It runs on the writer.
+func (p *Pool) Exec(ctx context.Context, query string, args, v any) error {
+	return p.writer.Exec(ctx, query, args, v)
+}
+
+// Query implements dialect.ExecQuerier. SELECT queries run on the readers,
+// other statements, e.g. INSERT ... RETURNING, on the writer.
+func (p *Pool) Query(ctx context.Context, query string, args, v any) error {
+	if isSelect(query) {
+		return p.reader.Query(ctx, query, args, v)
+	}
+	return p.writer.Query(ctx, query, args, v)
+}
+
+// Tx implements dialect.Driver. The transaction runs on the writer.
+func (p *Pool) Tx(ctx context.Context) (dialect.Tx, error) {
+	return p.writer.Tx(ctx)
+}
+
+// BeginTx starts a transaction with opts. Read-only transactions run on the
+// readers, others on the writer.
+func (p *Pool) BeginTx(ctx context.Context, opts *entsql.TxOptions) (dialect.Tx, error) {
+	if opts != nil && opts.ReadOnly {
+		return p.reader.BeginTx(ctx, opts)
+	}
+	return p.writer.BeginTx(ctx, opts)
+}
+
+// Close implements dialect.Driver. It closes both pools.
+func (p *Pool) Close() error {
+	return errors.Join(p.reader.Close(), p.writer.Close())
+}
+
+// Dialect implements dialect.Driver.
+func (p *Pool) Dialect() string {
+	return dialect.SQLite
+}
+
+// withParam adds key=value to the parameters of dsn unless one of keys is
+// already set.
+func withParam(dsn, key, value string, keys ...string) (string, error) {
+	base, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		if params.Has(k) {
+			return dsn, nil
+		}
+	}
+	if query != "" {
+		query += "&"
+	}
+	return base + "?" + query + key + "=" + value, nil
+}
+
+// isSelect reports whether query is a SELECT statement.
+func isSelect(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT")
+}
@@ -0,0 +1,86 @@
+package entdriver
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+func TestPool(t *testing.T) {
+	pool, err := OpenPool(Config{DSN: "file:" + filepath.Join(t.TempDir(), "test.db") + "?_fk=1", Readers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	ctx := context.Background()
+	if err := pool.Exec(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, n INTEGER)`, []any{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent transactions queue for the writer instead of failing.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Go(func() {
+			tx, err := pool.Tx(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var rows entsql.Rows
+			if err := tx.Query(ctx, `SELECT COUNT(*) FROM test`, []any{}, &rows); err != nil {
+				_ = tx.Rollback()
+				errs <- err
+				return
+			}
+			rows.Close()
+			if err := tx.Exec(ctx, `INSERT INTO test (n) VALUES (?)`, []any{i}, nil); err != nil {
+				_ = tx.Rollback()
+				errs <- err
+				return
+			}
+			errs <- tx.Commit()
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rows entsql.Rows
+	if err := pool.Query(ctx, `SELECT COUNT(*) FROM test`, []any{}, &rows); err != nil {
+		t.Fatal(err)
+	}
+	count, err := entsql.ScanInt(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 20 {
+		t.Fatalf("expected count to be 20, but got %d", count)
+	}
+	if n := pool.Reader().Stats().OpenConnections; n == 0 {
+		t.Fatal("expected the SELECT to run on the readers")
+	}
+
+	// The readers are read-only.
+	if _, err := pool.Reader().ExecContext(ctx, `INSERT INTO test (n) VALUES (1)`); err == nil {
+		t.Fatal("expected a write on the readers to fail")
+	}
+
+	// Read-only transactions run on the readers.
+	tx, err := pool.BeginTx(ctx, &entsql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := tx.Exec(ctx, `DELETE FROM test`, []any{}, nil); err == nil {
+		t.Fatal("expected a write in a read-only transaction to fail")
+	}
+}
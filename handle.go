@@ -0,0 +1,82 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteHandle is the C-level state of a modernc.org/sqlite connection, for
+// the parts of the SQLite API the package does not expose.
+type sqliteHandle struct {
+	db  uintptr // *sqlite3
+	tls *libc.TLS
+}
+
+// handleOf returns the sqlite3* handle and the TLS of c.
+//
+// modernc.org/sqlite keeps both in unexported fields of its connection, so
+// they are read by reflection. The TLS must only be used from the goroutine
+// that currently owns the connection.
+func handleOf(c sqliteConn) (sqliteHandle, error) {
+	v := reflect.ValueOf(c)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return sqliteHandle{}, fmt.Errorf("sqlite3: unexpected connection type %T", c)
+	}
+	db, tls := v.FieldByName("db"), v.FieldByName("tls")
+	if db.Kind() != reflect.Uintptr || tls.Kind() != reflect.Pointer || tls.Type().Elem() != reflect.TypeFor[libc.TLS]() {
+		return sqliteHandle{}, fmt.Errorf("sqlite3: unexpected connection type %T", c)
+	}
+	if db.Uint() == 0 || tls.IsNil() {
+		return sqliteHandle{}, fmt.Errorf("sqlite3: connection is closed")
+	}
+	return sqliteHandle{db: uintptr(db.Uint()), tls: (*libc.TLS)(tls.UnsafePointer())}, nil
+}
+
+// ptr converts an address in C memory into an unsafe.Pointer.
+func ptr(p uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&p))
+}
+
+// cFuncPointer returns the C function pointer SQLite calls f through.
+//
+// This assumes the memory representation of func values described in
+// https://golang.org/s/go11func, the same way modernc.org/sqlite does.
+func cFuncPointer[T any](f T) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&struct{ f T }{f}))
+}
+
+// resultError is an error of the C API, for the calls the package makes
+// itself. Like sqlite.Error, it reports its result code with Code.
+type resultError struct {
+	msg  string
+	code int
+}
+
+func (e *resultError) Error() string { return e.msg }
+
+// Code returns the SQLite result code of e.
+func (e *resultError) Code() int { return e.code }
+
+// errorOf returns the error of the result code rc of a call on h.
+func (h sqliteHandle) errorOf(rc int32) error {
+	msg := libc.GoString(sqlite3.Xsqlite3_errmsg(h.tls, h.db))
+	return &resultError{msg: fmt.Sprintf("%s (%d)", msg, rc), code: int(rc)}
+}
+
+// errorCode returns the SQLite result code of err, if it has one.
+func errorCode(err error) (int, bool) {
+	var coder interface{ Code() int }
+	if errors.As(err, &coder) {
+		return coder.Code(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Schema is the schema of the main database of a connection, as read by
+// Inspect.
+type Schema struct {
+	// Tables are the tables of the database, sorted by name. The internal
+	// sqlite_* tables are left out.
+	Tables []*Table
+}
+
+// Table returns the table name, or nil if there is none.
+func (s *Schema) Table(name string) *Table {
+	for _, t := range s.Tables {
+		if strings.EqualFold(t.Name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Table is a table of a Schema.
+type Table struct {
+	Name         string
+	SQL          string // CREATE TABLE statement
+	WithoutRowID bool
+	Strict       bool
+	Columns      []*Column // in table order
+	Indexes      []*Index  // sorted by name
+	ForeignKeys  []*ForeignKey
+}
+
+// Column returns the column name of t, or nil if there is none.
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Index returns the index name of t, or nil if there is none.
+func (t *Table) Index(name string) *Index {
+	for _, idx := range t.Indexes {
+		if strings.EqualFold(idx.Name, name) {
+			return idx
+		}
+	}
+	return nil
+}
+
+// Column is a column of a Table.
+type Column struct {
+	Name    string
+	Type    string // declared type, e.g. "INTEGER" or "" if none
+	NotNull bool
+	Default string // SQL expression of the default value, "" if none
+	// PrimaryKey is the position of the column in the primary key of the
+	// table, from 1, or 0 if it is not part of it.
+	PrimaryKey int
+	Generated  Generated
+}
+
+// Generated tells whether and how a column is generated.
+type Generated int
+
+const (
+	GeneratedNone    Generated = iota // an ordinary column
+	GeneratedVirtual                  // GENERATED ALWAYS AS (...) VIRTUAL
+	GeneratedStored                   // GENERATED ALWAYS AS (...) STORED
+)
+
+// Index is an index of a Table.
+type Index struct {
+	Name   string
+	SQL    string // CREATE INDEX statement, "" for automatic indexes
+	Unique bool
+	// Origin is "c" for an index created by CREATE INDEX, "u" for one of a
+	// UNIQUE constraint and "pk" for one of a PRIMARY KEY.
+	Origin  string
+	Partial bool
+	// Columns are the key columns of the index followed by its auxiliary
+	// columns, see Key.
+	Columns []IndexColumn
+}
+
+// IndexColumn is a column of an Index.
+type IndexColumn struct {
+	// Name is the name of the column, "" for an expression or the rowid.
+	Name string
+	// Expression is true for an expression, whose text is only in the SQL
+	// of the index.
+	Expression bool
+	Desc       bool
+	Collation  string
+	// Key is false for the auxiliary columns SQLite appends to identify
+	// rows, e.g. the rowid.
+	Key bool
+}
+
+// ForeignKey is a foreign key constraint of a Table.
+type ForeignKey struct {
+	Columns  []string
+	RefTable string
+	// RefColumns are the referenced columns, "" for the columns of the
+	// primary key of RefTable when the constraint does not name them.
+	RefColumns []string
+	OnUpdate   string // e.g. "NO ACTION" or "CASCADE"
+	OnDelete   string
+	Match      string
+}
+
+// Inspect reads the schema of the main database of db from sqlite_schema
+// and the table_list, table_xinfo, index_list, index_xinfo and
+// foreign_key_list PRAGMAs, in one read transaction.
+func Inspect(ctx context.Context, db *sql.DB) (*Schema, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s := &Schema{}
+	err = queryEach(ctx, tx, func(rows *sql.Rows) error {
+		t := &Table{}
+		if err := rows.Scan(&t.Name, &t.SQL, &t.WithoutRowID, &t.Strict); err != nil {
+			return err
+		}
+		s.Tables = append(s.Tables, t)
+		return nil
+	}, `SELECT l.name, s.sql, l.wr, l.strict
+		FROM pragma_table_list AS l JOIN sqlite_schema AS s ON s.type = 'table' AND s.name = l.name
+		WHERE l.schema = 'main' AND l.type = 'table' AND l.name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY l.name`)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range s.Tables {
+		if err := inspectTable(ctx, tx, t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// inspectTable reads the columns, indexes and foreign keys of t.
+func inspectTable(ctx context.Context, tx *sql.Tx, t *Table) error {
+	err := queryEach(ctx, tx, func(rows *sql.Rows) error {
+		c := &Column{}
+		var dflt sql.NullString
+		var hidden int
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &dflt, &c.PrimaryKey, &hidden); err != nil {
+			return err
+		}
+		c.Default = dflt.String
+		switch hidden {
+		case 2:
+			c.Generated = GeneratedVirtual
+		case 3:
+			c.Generated = GeneratedStored
+		}
+		t.Columns = append(t.Columns, c)
+		return nil
+	}, `SELECT name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, t.Name)
+	if err != nil {
+		return err
+	}
+
+	err = queryEach(ctx, tx, func(rows *sql.Rows) error {
+		idx := &Index{}
+		var sqlText sql.NullString
+		if err := rows.Scan(&idx.Name, &sqlText, &idx.Unique, &idx.Origin, &idx.Partial); err != nil {
+			return err
+		}
+		idx.SQL = sqlText.String
+		t.Indexes = append(t.Indexes, idx)
+		return nil
+	}, `SELECT l.name, s.sql, l."unique", l.origin, l.partial
+		FROM pragma_index_list(?) AS l LEFT JOIN sqlite_schema AS s ON s.type = 'index' AND s.name = l.name
+		ORDER BY l.name`, t.Name)
+	if err != nil {
+		return err
+	}
+	for _, idx := range t.Indexes {
+		err := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var c IndexColumn
+			var cid int
+			var name, coll sql.NullString
+			if err := rows.Scan(&cid, &name, &c.Desc, &coll, &c.Key); err != nil {
+				return err
+			}
+			c.Name, c.Expression, c.Collation = name.String, cid == -2, coll.String
+			idx.Columns = append(idx.Columns, c)
+			return nil
+		}, `SELECT cid, name, "desc", coll, "key" FROM pragma_index_xinfo(?) ORDER BY seqno`, idx.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	id := -1
+	return queryEach(ctx, tx, func(rows *sql.Rows) error {
+		var fid int
+		var from string
+		var to sql.NullString
+		fk := &ForeignKey{}
+		if err := rows.Scan(&fid, &fk.RefTable, &from, &to, &fk.OnUpdate, &fk.OnDelete, &fk.Match); err != nil {
+			return err
+		}
+		if fid != id {
+			id = fid
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		}
+		fk = t.ForeignKeys[len(t.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, from)
+		fk.RefColumns = append(fk.RefColumns, to.String)
+		return nil
+	}, `SELECT id, "table", "from", "to", on_update, on_delete, "match" FROM pragma_foreign_key_list(?) ORDER BY id DESC, seq`, t.Name)
+}
+
+// queryer is a *sql.DB, *sql.Conn or *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// queryEach runs query on q and calls fn for each of its rows.
+func queryEach(ctx context.Context, q queryer, fn func(*sql.Rows) error, query string, args ...any) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,112 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, stmt := range []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			first TEXT DEFAULT '',
+			last TEXT,
+			full TEXT GENERATED ALWAYS AS (first || ' ' || last) VIRTUAL,
+			lower_email TEXT GENERATED ALWAYS AS (lower(email)) STORED
+		)`,
+		`CREATE INDEX users_name ON users (last DESC, first) WHERE last IS NOT NULL`,
+		`CREATE INDEX users_full ON users (lower(first))`,
+		`CREATE TABLE tags (owner INTEGER, name TEXT, PRIMARY KEY (owner, name)) WITHOUT ROWID, STRICT`,
+		`CREATE TABLE links (
+			user_id INTEGER REFERENCES users ON DELETE CASCADE,
+			owner INTEGER,
+			tag TEXT,
+			FOREIGN KEY (owner, tag) REFERENCES tags (owner, name) ON UPDATE SET NULL
+		)`,
+		`CREATE VIEW names AS SELECT first FROM users`,
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := Inspect(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tbl := range s.Tables {
+		names = append(names, tbl.Name)
+	}
+	if want := []string{"links", "tags", "users"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected tables %q, but got %q", want, names)
+	}
+
+	users := s.Table("users")
+	want := []*Column{
+		{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+		{Name: "email", Type: "TEXT", NotNull: true},
+		{Name: "first", Type: "TEXT", Default: "''"},
+		{Name: "last", Type: "TEXT"},
+		{Name: "full", Type: "TEXT", Generated: GeneratedVirtual},
+		{Name: "lower_email", Type: "TEXT", Generated: GeneratedStored},
+	}
+	if !reflect.DeepEqual(users.Columns, want) {
+		t.Fatalf("expected columns %+v, but got %+v", want, users.Columns)
+	}
+	if users.WithoutRowID || users.Strict {
+		t.Fatal("expected users to be an ordinary rowid table")
+	}
+
+	idx := users.Index("users_name")
+	if idx == nil || !idx.Partial || idx.Unique || idx.Origin != "c" || idx.SQL == "" {
+		t.Fatalf("expected the partial index users_name, but got %+v", idx)
+	}
+	wantCols := []IndexColumn{
+		{Name: "last", Desc: true, Collation: "BINARY", Key: true},
+		{Name: "first", Collation: "BINARY", Key: true},
+		{Collation: "BINARY"},
+	}
+	if !reflect.DeepEqual(idx.Columns, wantCols) {
+		t.Fatalf("expected index columns %+v, but got %+v", wantCols, idx.Columns)
+	}
+	if idx := users.Index("users_full"); idx == nil || !idx.Columns[0].Expression {
+		t.Fatalf("expected an expression index, but got %+v", idx)
+	}
+	var unique *Index
+	for _, idx := range users.Indexes {
+		if idx.Origin == "u" {
+			unique = idx
+		}
+	}
+	if unique == nil || !unique.Unique || unique.SQL != "" || unique.Columns[0].Collation != "NOCASE" {
+		t.Fatalf("expected the automatic index of email, but got %+v", unique)
+	}
+
+	tags := s.Table("tags")
+	if !tags.WithoutRowID || !tags.Strict {
+		t.Fatal("expected tags to be a strict WITHOUT ROWID table")
+	}
+	if c := tags.Column("name"); c.PrimaryKey != 2 {
+		t.Fatalf("expected name to be the second column of the primary key, but got %d", c.PrimaryKey)
+	}
+
+	wantFKs := []*ForeignKey{
+		{Columns: []string{"user_id"}, RefTable: "users", RefColumns: []string{""}, OnUpdate: "NO ACTION", OnDelete: "CASCADE", Match: "NONE"},
+		{Columns: []string{"owner", "tag"}, RefTable: "tags", RefColumns: []string{"owner", "name"}, OnUpdate: "SET NULL", OnDelete: "NO ACTION", Match: "NONE"},
+	}
+	if fks := s.Table("links").ForeignKeys; !reflect.DeepEqual(fks, wantFKs) {
+		t.Fatalf("expected foreign keys %+v, but got %+v", wantFKs, fks)
+	}
+}
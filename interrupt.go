@@ -0,0 +1,83 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// watch makes the statements run on c until stop is called give up once ctx
+// is done: it calls sqlite3_interrupt on the connection, which stops a
+// running statement at its next VDBE instruction, and it makes the busy
+// handler stop waiting for locks.
+//
+// modernc.org/sqlite interrupts on its own the first step of a statement
+// only; watch also covers the steps of rows.Next and the busy waits of
+// BEGIN IMMEDIATE. stop clears the interruption, so that the connection
+// can be reused.
+func (c *SQLiteConn) watch(ctx context.Context) (stop func()) {
+	prev := c.runCtx
+	c.runCtx = ctx
+	done := ctx.Done()
+	h, err := handleOf(c.conn)
+	if done == nil || err != nil {
+		return func() { c.runCtx = prev }
+	}
+
+	var mu sync.Mutex // orders the interrupt before the return of stop
+	stopped := false
+	stopc := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			mu.Lock()
+			defer mu.Unlock()
+			if !stopped {
+				// sqlite3_interrupt only sets a flag on the connection,
+				// which is safe from any thread.
+				tls := libc.NewTLS()
+				sqlite3.Xsqlite3_interrupt(tls, h.db)
+				tls.Close()
+			}
+		case <-stopc:
+		}
+	}()
+	return func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+		close(stopc)
+		c.runCtx = prev
+		c.clearInterrupt(h)
+	}
+}
+
+// clearInterrupt resets the interruption of the connection of h once its
+// statement stopped. SQLite only resets it when the next statement starts,
+// and until then modernc.org/sqlite reports the connection as broken, so
+// that database/sql would discard it. The interruption stays if other
+// statements still run on the connection.
+func (c *SQLiteConn) clearInterrupt(h sqliteHandle) {
+	if sqlite3.Xsqlite3_is_interrupted(h.tls, h.db) == 0 {
+		return
+	}
+	_, _ = c.conn.ExecContext(context.Background(), "SELECT 1", nil)
+}
+
+// ctxErr returns the error of ctx instead of err if err is the
+// SQLITE_INTERRUPT or SQLITE_BUSY a statement failed with because ctx is done.
+func ctxErr(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if code, ok := errorCode(err); ok {
+		switch code & 0xff {
+		case sqlite3.SQLITE_INTERRUPT, sqlite3.SQLITE_BUSY:
+			return ctx.Err()
+		}
+	}
+	return err
+}
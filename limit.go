@@ -0,0 +1,53 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Limit is a run-time limit of a connection, see
+// https://sqlite.org/c3ref/c_limit_attached.html.
+type Limit int
+
+const (
+	LimitLength            Limit = sqlite3.SQLITE_LIMIT_LENGTH
+	LimitSQLLength         Limit = sqlite3.SQLITE_LIMIT_SQL_LENGTH
+	LimitColumn            Limit = sqlite3.SQLITE_LIMIT_COLUMN
+	LimitExprDepth         Limit = sqlite3.SQLITE_LIMIT_EXPR_DEPTH
+	LimitCompoundSelect    Limit = sqlite3.SQLITE_LIMIT_COMPOUND_SELECT
+	LimitVDBEOp            Limit = sqlite3.SQLITE_LIMIT_VDBE_OP
+	LimitFunctionArg       Limit = sqlite3.SQLITE_LIMIT_FUNCTION_ARG
+	LimitAttached          Limit = sqlite3.SQLITE_LIMIT_ATTACHED
+	LimitLikePatternLength Limit = sqlite3.SQLITE_LIMIT_LIKE_PATTERN_LENGTH
+	LimitVariableNumber    Limit = sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER
+	LimitTriggerDepth      Limit = sqlite3.SQLITE_LIMIT_TRIGGER_DEPTH
+	LimitWorkerThreads     Limit = sqlite3.SQLITE_LIMIT_WORKER_THREADS
+)
+
+// limitParams are the DSN parameters setting the limits of a connection.
+var limitParams = map[string]Limit{
+	"_limit_length":              LimitLength,
+	"_limit_sql_length":          LimitSQLLength,
+	"_limit_column":              LimitColumn,
+	"_limit_expr_depth":          LimitExprDepth,
+	"_limit_compound_select":     LimitCompoundSelect,
+	"_limit_vdbe_op":             LimitVDBEOp,
+	"_limit_function_arg":        LimitFunctionArg,
+	"_limit_attached":            LimitAttached,
+	"_limit_like_pattern_length": LimitLikePatternLength,
+	"_limit_variable_number":     LimitVariableNumber,
+	"_limit_trigger_depth":       LimitTriggerDepth,
+	"_limit_worker_threads":      LimitWorkerThreads,
+}
+
+// SetLimit sets the limit id of the connection to value and returns its
+// previous value. Values above the hard limit SQLite was compiled with are
+// truncated to it; a negative value leaves the limit unchanged, so
+// SetLimit(id, -1) reads it.
+func (c *SQLiteConn) SetLimit(id Limit, value int) (int, error) {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return 0, err
+	}
+	return int(sqlite3.Xsqlite3_limit(h.tls, h.db, int32(id), int32(value))), nil
+}
@@ -0,0 +1,51 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_limit_sql_length=100&_limit_variable_number=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT ? + ?`, 1, 2).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT ? + ? + ?`, 1, 2, 3).Scan(&n); err == nil {
+		t.Fatal("expected too many variables to fail")
+	}
+	if err := db.QueryRowContext(ctx, `SELECT 1`+strings.Repeat(" ", 100)).Scan(&n); err == nil {
+		t.Fatal("expected a statement too long to fail")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Raw(func(dc any) error {
+		c := dc.(*SQLiteConn)
+		if prev, err := c.SetLimit(LimitSQLLength, 1000); err != nil || prev != 100 {
+			t.Fatalf("expected the previous limit to be 100, but got %d, %v", prev, err)
+		}
+		if cur, err := c.SetLimit(LimitSQLLength, -1); err != nil || cur != 1000 {
+			t.Fatalf("expected the limit to be 1000, but got %d, %v", cur, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT 1`+strings.Repeat(" ", 100)).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+}
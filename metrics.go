@@ -0,0 +1,239 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// LatencyBuckets are the upper bounds of the statement latency histograms of
+// Metrics.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Metrics collects statistics about the connections of a Connector. Set it
+// as the Metrics of the Connector, then read it with Stats or export it in
+// the Prometheus text format with WritePrometheus.
+//
+// Statements are counted once they returned; Prepare is not counted.
+type Metrics struct {
+	openConns       atomic.Int64
+	busyErrors      atomic.Int64
+	busyWaits       atomic.Int64
+	busyWaitTime    atomic.Int64 // nanoseconds
+	cacheHits       atomic.Int64
+	cacheMisses     atomic.Int64
+	stmtCacheHits   atomic.Int64
+	stmtCacheMisses atomic.Int64
+	walPath         atomic.Pointer[string]
+
+	mu         sync.Mutex
+	statements map[string]*StatementStats
+}
+
+// Stats is a snapshot of Metrics.
+type Stats struct {
+	// OpenConnections is the number of connections open.
+	OpenConnections int64
+	// Statements are the statistics of statements by verb, e.g. "SELECT".
+	Statements map[string]StatementStats
+	// BusyErrors is the number of statements that failed with SQLITE_BUSY.
+	BusyErrors int64
+	// BusyWaits is the number of times a connection waited for a lock.
+	BusyWaits int64
+	// BusyWaitTime is the total time connections waited for locks.
+	BusyWaitTime time.Duration
+	// CacheHits and CacheMisses count the page cache hits and misses of
+	// the connections, see SQLITE_DBSTATUS_CACHE_HIT.
+	CacheHits   int64
+	CacheMisses int64
+	// StmtCacheHits and StmtCacheMisses count the statements found and not
+	// found in the statement cache enabled by _stmt_cache.
+	StmtCacheHits   int64
+	StmtCacheMisses int64
+	// WALSize is the size of the -wal file in bytes, or 0 if there is none.
+	WALSize int64
+}
+
+// StatementStats are the statistics of the statements of one verb.
+type StatementStats struct {
+	// Count is the number of statements run.
+	Count int64
+	// Errors is the number of statements that failed.
+	Errors int64
+	// Duration is the total time the statements took.
+	Duration time.Duration
+	// Buckets are the cumulative number of statements that took at most
+	// the LatencyBuckets of the same index.
+	Buckets []int64
+}
+
+// Stats returns a snapshot of the statistics collected so far.
+func (m *Metrics) Stats() Stats {
+	s := Stats{
+		OpenConnections: m.openConns.Load(),
+		Statements:      map[string]StatementStats{},
+		BusyErrors:      m.busyErrors.Load(),
+		BusyWaits:       m.busyWaits.Load(),
+		BusyWaitTime:    time.Duration(m.busyWaitTime.Load()),
+		CacheHits:       m.cacheHits.Load(),
+		CacheMisses:     m.cacheMisses.Load(),
+		StmtCacheHits:   m.stmtCacheHits.Load(),
+		StmtCacheMisses: m.stmtCacheMisses.Load(),
+	}
+	if p := m.walPath.Load(); p != nil {
+		if fi, err := os.Stat(*p); err == nil {
+			s.WALSize = fi.Size()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for verb, st := range m.statements {
+		st := *st
+		st.Buckets = slices.Clone(st.Buckets)
+		s.Statements[verb] = st
+	}
+	return s
+}
+
+// WritePrometheus writes the statistics collected so far to w in the
+// Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.Stats()
+	verbs := make([]string, 0, len(s.Statements))
+	for verb := range s.Statements {
+		verbs = append(verbs, verb)
+	}
+	slices.Sort(verbs)
+
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("sqlite_statements_total", "counter", "Statements run, by verb.")
+	for _, verb := range verbs {
+		fmt.Fprintf(&b, "sqlite_statements_total{verb=%q} %d\n", verb, s.Statements[verb].Count)
+	}
+	metric("sqlite_statement_errors_total", "counter", "Statements that failed, by verb.")
+	for _, verb := range verbs {
+		fmt.Fprintf(&b, "sqlite_statement_errors_total{verb=%q} %d\n", verb, s.Statements[verb].Errors)
+	}
+	metric("sqlite_statement_duration_seconds", "histogram", "Latency of statements, by verb.")
+	for _, verb := range verbs {
+		st := s.Statements[verb]
+		for i, le := range LatencyBuckets {
+			fmt.Fprintf(&b, "sqlite_statement_duration_seconds_bucket{verb=%q,le=%q} %d\n", verb, seconds(le), st.Buckets[i])
+		}
+		fmt.Fprintf(&b, "sqlite_statement_duration_seconds_bucket{verb=%q,le=\"+Inf\"} %d\n", verb, st.Count)
+		fmt.Fprintf(&b, "sqlite_statement_duration_seconds_sum{verb=%q} %s\n", verb, seconds(st.Duration))
+		fmt.Fprintf(&b, "sqlite_statement_duration_seconds_count{verb=%q} %d\n", verb, st.Count)
+	}
+	metric("sqlite_busy_errors_total", "counter", "Statements that failed with SQLITE_BUSY.")
+	fmt.Fprintf(&b, "sqlite_busy_errors_total %d\n", s.BusyErrors)
+	metric("sqlite_busy_waits_total", "counter", "Waits for a locked database.")
+	fmt.Fprintf(&b, "sqlite_busy_waits_total %d\n", s.BusyWaits)
+	metric("sqlite_busy_wait_seconds_total", "counter", "Time spent waiting for a locked database.")
+	fmt.Fprintf(&b, "sqlite_busy_wait_seconds_total %s\n", seconds(s.BusyWaitTime))
+	metric("sqlite_cache_hits_total", "counter", "Page cache hits.")
+	fmt.Fprintf(&b, "sqlite_cache_hits_total %d\n", s.CacheHits)
+	metric("sqlite_cache_misses_total", "counter", "Page cache misses.")
+	fmt.Fprintf(&b, "sqlite_cache_misses_total %d\n", s.CacheMisses)
+	metric("sqlite_stmt_cache_hits_total", "counter", "Statements found in the statement cache.")
+	fmt.Fprintf(&b, "sqlite_stmt_cache_hits_total %d\n", s.StmtCacheHits)
+	metric("sqlite_stmt_cache_misses_total", "counter", "Statements prepared on a statement cache miss.")
+	fmt.Fprintf(&b, "sqlite_stmt_cache_misses_total %d\n", s.StmtCacheMisses)
+	metric("sqlite_wal_size_bytes", "gauge", "Size of the write-ahead log file.")
+	fmt.Fprintf(&b, "sqlite_wal_size_bytes %d\n", s.WALSize)
+	metric("sqlite_open_connections", "gauge", "Open connections.")
+	fmt.Fprintf(&b, "sqlite_open_connections %d\n", s.OpenConnections)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// connect starts collecting the statistics of c.
+func (m *Metrics) connect(c *SQLiteConn) {
+	m.openConns.Add(1)
+	if m.walPath.Load() == nil {
+		var file string
+		if err := c.queryRow(context.Background(), "SELECT file FROM pragma_database_list WHERE name = 'main'", &file); err == nil && file != "" {
+			wal := file + "-wal"
+			m.walPath.CompareAndSwap(nil, &wal)
+		}
+	}
+}
+
+func (m *Metrics) statementEnd(c *SQLiteConn, op Operation, query string, d time.Duration, err error) {
+	if op == OperationPrepare {
+		return
+	}
+	m.cacheStats(c)
+	if isBusy(err) {
+		m.busyErrors.Add(1)
+	}
+
+	v := Verb(query)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.statements == nil {
+		m.statements = map[string]*StatementStats{}
+	}
+	st := m.statements[v]
+	if st == nil {
+		st = &StatementStats{Buckets: make([]int64, len(LatencyBuckets))}
+		m.statements[v] = st
+	}
+	st.Count++
+	if err != nil {
+		st.Errors++
+	}
+	st.Duration += d
+	for i, le := range LatencyBuckets {
+		if d <= le {
+			st.Buckets[i]++
+		}
+	}
+}
+
+// cacheStats moves the page cache counters of c into m.
+func (m *Metrics) cacheStats(c *SQLiteConn) {
+	if hits, _, err := c.conn.Status(sqlite.DBStatusCacheHit, true); err == nil {
+		m.cacheHits.Add(int64(hits))
+	}
+	if misses, _, err := c.conn.Status(sqlite.DBStatusCacheMiss, true); err == nil {
+		m.cacheMisses.Add(int64(misses))
+	}
+}
+
+// isBusy reports whether err is a SQLITE_BUSY error, extended or not.
+func isBusy(err error) bool {
+	code, ok := errorCode(err)
+	return ok && code&0xff == sqlite3.SQLITE_BUSY
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := &Metrics{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal=WAL")
+	c.Metrics = metrics
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := db.ExecContext(ctx, `INSERT INTO test (name) VALUES (?)`, "name"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO missing (name) VALUES (?)`, "name"); err == nil {
+		t.Fatal("expected insert into a missing table to fail")
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	s := metrics.Stats()
+	if s.OpenConnections != 1 {
+		t.Fatalf("expected 1 open connection, but got %d", s.OpenConnections)
+	}
+	if st := s.Statements["INSERT"]; st.Count != 4 || st.Errors != 1 || st.Buckets[len(st.Buckets)-1] != 4 {
+		t.Fatalf("unexpected INSERT stats: %+v", st)
+	}
+	if st := s.Statements["SELECT"]; st.Count != 1 || st.Errors != 0 {
+		t.Fatalf("unexpected SELECT stats: %+v", st)
+	}
+	if s.CacheHits+s.CacheMisses == 0 {
+		t.Fatal("expected page cache statistics")
+	}
+	if s.WALSize == 0 {
+		t.Fatal("expected the WAL size")
+	}
+
+	var b strings.Builder
+	if err := metrics.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`sqlite_statements_total{verb="INSERT"} 4`,
+		`sqlite_statement_errors_total{verb="INSERT"} 1`,
+		`sqlite_statement_duration_seconds_count{verb="SELECT"} 1`,
+		`sqlite_open_connections 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("expected %q in:\n%s", line, b.String())
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := metrics.Stats().OpenConnections; n != 0 {
+		t.Fatalf("expected 0 open connections, but got %d", n)
+	}
+}
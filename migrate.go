@@ -0,0 +1,230 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// ErrMigrationChanged is returned by Migrate and MigrateDown when a migration
+// recorded as applied was changed or removed since.
+var ErrMigrationChanged = errors.New("sqlite3: applied migration changed")
+
+// migrationRE matches the names of migration files, NNNN_name.up.sql and
+// NNNN_name.down.sql.
+var migrationRE = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// migration is a versioned migration read from a migration directory.
+type migration struct {
+	version  int64
+	name     string
+	up, down string
+	checksum string // SHA-256 of up
+}
+
+// Migrate applies the migrations of fsys not applied to db yet, in order of
+// version.
+//
+// The migrations are the NNNN_name.up.sql files of the root of fsys, NNNN
+// being the version, each with an optional NNNN_name.down.sql file undoing
+// it for MigrateDown. Every migration runs in its own transaction, which
+// records it in the schema_migrations table with the checksum of its file.
+// When foreign keys are enforced, e.g. with _fk=1, they are turned off while
+// a migration runs and checked with foreign_key_check before it commits, so
+// that migrations can rebuild tables.
+//
+// Migrate fails with ErrMigrationChanged without applying anything if a
+// migration applied before was changed or removed.
+func Migrate(ctx context.Context, db *sql.DB, fsys fs.FS) error {
+	migrations, err := readMigrations(fsys)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn, migrations)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err := runMigration(ctx, conn, m, m.up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))`, m.version, m.name, m.checksum)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the migrations of fsys applied to db whose version is
+// greater than version, newest first, with their down files; see Migrate.
+// MigrateDown(ctx, db, fsys, 0) reverts them all.
+func MigrateDown(ctx context.Context, db *sql.DB, fsys fs.FS, version int64) error {
+	migrations, err := readMigrations(fsys)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn, migrations)
+	if err != nil {
+		return err
+	}
+	for _, m := range slices.Backward(migrations) {
+		if !applied[m.version] || m.version <= version {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("sqlite3: migration %d_%s has no down file", m.version, m.name)
+		}
+		err := runMigration(ctx, conn, m, m.down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMigrations returns the migrations of fsys sorted by version.
+func readMigrations(fsys fs.FS) ([]*migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*migration{}
+	for _, e := range entries {
+		match := migrationRE.FindStringSubmatch(e.Name())
+		if match == nil || e.IsDir() {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: invalid migration version %s", e.Name())
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("sqlite3: migrations %d_%s and %d_%s have the same version", version, m.name, version, match[2])
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			sum := sha256.Sum256(b)
+			m.up, m.checksum = string(b), hex.EncodeToString(sum[:])
+		} else {
+			m.down = string(b)
+		}
+	}
+
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("sqlite3: migration %d_%s has no up file", m.version, m.name)
+		}
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b *migration) int { return cmp.Compare(a.version, b.version) })
+	return migrations, nil
+}
+
+// appliedMigrations creates the schema_migrations table if needed and
+// returns the versions of the migrations applied, after checking them
+// against migrations.
+func appliedMigrations(ctx context.Context, conn *sql.Conn, migrations []*migration) (map[int64]bool, error) {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]bool{}
+	err = queryEach(ctx, conn, func(rows *sql.Rows) error {
+		var version int64
+		var name, checksum string
+		if err := rows.Scan(&version, &name, &checksum); err != nil {
+			return err
+		}
+		i := slices.IndexFunc(migrations, func(m *migration) bool { return m.version == version })
+		if i < 0 {
+			return fmt.Errorf("%w: %d_%s is missing", ErrMigrationChanged, version, name)
+		}
+		if migrations[i].checksum != checksum {
+			return fmt.Errorf("%w: %d_%s has checksum %s, applied with %s", ErrMigrationChanged, version, name, migrations[i].checksum, checksum)
+		}
+		applied[version] = true
+		return nil
+	}, `SELECT version, name, checksum FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// runMigration runs script, the up or down file of m, and record in one
+// transaction on conn, with foreign keys turned off and checked.
+func runMigration(ctx context.Context, conn *sql.Conn, m *migration, script string, record func(*sql.Tx) error) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("sqlite3: migration %d_%s: %w", m.version, m.name, err)
+		}
+	}()
+	fk, restore, err := foreignKeysOff(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := restore(); err == nil {
+			err = rerr
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	if fk {
+		if err := foreignKeyCheck(ctx, tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,97 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fsys := fstest.MapFS{
+		"0001_users.up.sql":   {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO users (name) VALUES ('a');`)},
+		"0001_users.down.sql": {Data: []byte(`DROP TABLE users;`)},
+		"0002_pets.up.sql":    {Data: []byte(`CREATE TABLE pets (id INTEGER PRIMARY KEY, owner INTEGER REFERENCES users);`)},
+		"0002_pets.down.sql":  {Data: []byte(`DROP TABLE pets;`)},
+		"README.md":           {Data: []byte(`not a migration`)},
+	}
+	ctx := context.Background()
+	if err := Migrate(ctx, db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db, fsys); err != nil {
+		t.Fatal(err)
+	}
+	versions := func() []int64 {
+		t.Helper()
+		var vs []int64
+		rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int64
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			vs = append(vs, v)
+		}
+		return vs
+	}
+	if vs := versions(); len(vs) != 2 {
+		t.Fatalf("expected 2 migrations applied, but got %v", vs)
+	}
+
+	// A failing migration is rolled back, foreign key violations included.
+	fsys["0003_bad.up.sql"] = &fstest.MapFile{Data: []byte(`INSERT INTO pets (owner) VALUES (1); INSERT INTO pets (owner) VALUES (42);`)}
+	if err := Migrate(ctx, db, fsys); err == nil {
+		t.Fatal("expected a migration violating a foreign key to fail")
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pets`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || len(versions()) != 2 {
+		t.Fatal("expected the failed migration to be rolled back")
+	}
+	delete(fsys, "0003_bad.up.sql")
+
+	fsys["0001_users.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)}
+	if err := Migrate(ctx, db, fsys); !errors.Is(err, ErrMigrationChanged) {
+		t.Fatalf("expected ErrMigrationChanged, but got %v", err)
+	}
+	fsys["0001_users.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO users (name) VALUES ('a');`)}
+
+	if err := MigrateDown(ctx, db, fsys, 1); err != nil {
+		t.Fatal(err)
+	}
+	if vs := versions(); len(vs) != 1 || vs[0] != 1 {
+		t.Fatalf("expected migration 1 only, but got %v", vs)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_schema WHERE name = 'pets'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected pets to be dropped")
+	}
+	if err := MigrateDown(ctx, db, fsys, 0); err != nil {
+		t.Fatal(err)
+	}
+	if vs := versions(); len(vs) != 0 {
+		t.Fatalf("expected no migration, but got %v", vs)
+	}
+
+	fsys["0001_other.up.sql"] = &fstest.MapFile{Data: []byte(`SELECT 1;`)}
+	if err := Migrate(ctx, db, fsys); err == nil {
+		t.Fatal("expected two migrations of the same version to fail")
+	}
+}
@@ -0,0 +1,104 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// inTx reports whether a transaction is open on c, whether it was started by
+// BeginTx or by a BEGIN statement.
+func (c *SQLiteConn) inTx() bool {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return false
+	}
+	return sqlite3.Xsqlite3_get_autocommit(h.tls, h.db) == 0
+}
+
+// beginSavepoint opens the savepoint of a transaction nested in the one open
+// on c. Its name is never reused within the transaction, so that it cannot
+// be mistaken for another savepoint still open.
+func (c *SQLiteConn) beginSavepoint(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	name := fmt.Sprintf("sp_%d", c.savepointSeq+1)
+	ctx, txStart := c.txStart(ctx, opts)
+	sctx, st := c.traceStart(ctx, OperationBegin, "SAVEPOINT "+name, nil)
+	stop := c.watch(sctx)
+	_, err := c.conn.ExecContext(sctx, "SAVEPOINT "+name, nil)
+	stop()
+	err = ctxErr(sctx, err)
+	c.traceEnd(sctx, st, -1, err)
+	if err != nil {
+		c.txEnd(ctx, txStart, false, err)
+		return nil, err
+	}
+	c.savepointSeq++
+	c.savepoints = append(c.savepoints, name)
+	return &SQLiteTx{c: c, ctx: ctx, start: txStart, savepoint: name, gen: c.txGen}, nil
+}
+
+// endTx forgets the savepoints of the transaction of c that ended.
+func (c *SQLiteConn) endTx() {
+	c.savepoints = c.savepoints[:0]
+	c.savepointSeq = 0
+	c.txGen++
+}
+
+// endSavepoint releases the savepoint of t, after rolling back to it unless
+// commit is set. It fails with sql.ErrTxDone if the outer transaction of t
+// ended, which ended t as well, and it fails without touching the savepoint
+// if a transaction nested in t is still open: the changes of t then go with
+// the transaction enclosing it.
+func (t *SQLiteTx) endSavepoint(commit bool) error {
+	c := t.c
+	if t.gen != c.txGen || !c.inTx() {
+		err := fmt.Errorf("sqlite3: savepoint %s: outer transaction ended: %w", t.savepoint, sql.ErrTxDone)
+		c.txEnd(t.ctx, t.start, false, err)
+		return err
+	}
+	i := slices.Index(c.savepoints, t.savepoint)
+	if i < 0 {
+		err := fmt.Errorf("sqlite3: savepoint %s: %w", t.savepoint, sql.ErrTxDone)
+		c.txEnd(t.ctx, t.start, false, err)
+		return err
+	}
+	c.savepoints = slices.Delete(c.savepoints, i, i+1)
+	if i < len(c.savepoints) {
+		err := fmt.Errorf("sqlite3: savepoint %s ended before the nested transaction %s", t.savepoint, c.savepoints[i])
+		c.txEnd(t.ctx, t.start, false, err)
+		return err
+	}
+	var err error
+	if !commit {
+		// Not interrupted by the context of t: database/sql rolls back
+		// the transactions whose context is canceled.
+		query := "ROLLBACK TO " + t.savepoint
+		ctx, st := c.traceStart(t.ctx, OperationRollback, query, nil)
+		_, err = c.conn.ExecContext(ctx, query, nil)
+		c.traceEnd(ctx, st, -1, err)
+	}
+	if err == nil {
+		op := OperationCommit
+		if !commit {
+			op = OperationRollback
+		}
+		query := "RELEASE " + t.savepoint
+		ctx, st := c.traceStart(t.ctx, op, query, nil)
+		if commit {
+			stop := c.watch(ctx)
+			_, err = c.conn.ExecContext(ctx, query, nil)
+			stop()
+			err = ctxErr(ctx, err)
+		} else {
+			_, err = c.conn.ExecContext(ctx, query, nil)
+		}
+		c.traceEnd(ctx, st, -1, err)
+	}
+	c.txEnd(t.ctx, t.start, commit && err == nil, err)
+	return err
+}
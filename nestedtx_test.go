@@ -0,0 +1,253 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNestedTx(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_nested_tx=savepoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	insert := func(tx *sql.Tx, name string) {
+		t.Helper()
+		if _, err := tx.ExecContext(ctx, `INSERT INTO test (name) VALUES (?)`, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outer, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(outer, "outer")
+
+	// A nested transaction rolled back discards its own writes only.
+	inner, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(inner, "rolled back")
+	deepest, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(deepest, "rolled back with its parent")
+	if err := deepest.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err = conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(inner, "committed")
+	if err := inner.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := outer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	rows, err := db.QueryContext(ctx, `SELECT name FROM test ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if len(names) != 2 || names[0] != "outer" || names[1] != "committed" {
+		t.Fatalf("expected [outer committed], but got %q", names)
+	}
+}
+
+func TestNestedTxDisabled(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := conn.BeginTx(ctx, nil); err == nil {
+		t.Fatal("expected a nested BeginTx to fail")
+	}
+}
+
+func TestNestedTxOuterEnded(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_nested_tx=savepoint")
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, end := range []string{"commit", "rollback"} {
+		outer, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inner, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if end == "commit" {
+			err = outer.Commit()
+		} else {
+			err = outer.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := inner.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+			t.Fatalf("expected ErrTxDone once the outer transaction ended by %s, but got %v", end, err)
+		}
+	}
+
+	// A transaction ended by a statement ends its savepoints too.
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	inner, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Commit(); !errors.Is(err, sql.ErrTxDone) {
+		t.Fatalf("expected ErrTxDone once the transaction was committed, but got %v", err)
+	}
+
+	// The savepoints are numbered from 1 in every transaction.
+	outer, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err = conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := outer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range tracer.queries() {
+		if strings.HasPrefix(query, "SAVEPOINT ") && query != "SAVEPOINT sp_1" {
+			t.Fatalf("expected only the savepoint sp_1, but got %s", query)
+		}
+	}
+}
+
+func TestNestedTxOutOfOrder(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_nested_tx=savepoint")
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	outer, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Rollback()
+	parent, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The parent cannot end before its child, which stays open.
+	if err := parent.Commit(); err == nil || !strings.Contains(err.Error(), "sp_2") {
+		t.Fatalf("expected an error naming the open child, but got %v", err)
+	}
+	if _, err := child.ExecContext(ctx, `INSERT INTO test VALUES ('child')`); err != nil {
+		t.Fatal(err)
+	}
+	next, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := next.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := outer.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected the child to be rolled back, but got %d rows", count)
+	}
+
+	// Names are not reused while the savepoint holding them is open.
+	seen := map[string]bool{}
+	for _, query := range tracer.queries() {
+		if strings.HasPrefix(query, "SAVEPOINT ") {
+			if seen[query] {
+				t.Fatalf("expected unique savepoint names, but got %s twice", query)
+			}
+			seen[query] = true
+		}
+	}
+	if !seen["SAVEPOINT sp_3"] {
+		t.Fatal("expected the savepoint sp_3")
+	}
+}
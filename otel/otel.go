@@ -0,0 +1,170 @@
+// Package otel emits OpenTelemetry spans for the connections, statements,
+// transactions and result sets of the sqlite3 driver.
+//
+// Set a Tracer on a sqlite3.Connector:
+//
+//	c := sqlite3.NewConnector("file:ent.db?_journal=WAL&_fk=1")
+//	c.Tracer = otel.NewTracer()
+//	db := sql.OpenDB(c)
+//
+// Spans are started from the context passed to ExecContext, QueryContext and
+// BeginTx, so they nest under the span of the caller.
+package otel
+
+import (
+	"context"
+	"database/sql/driver"
+	"runtime"
+	"sync"
+	"time"
+	"weak"
+
+	"github.com/sqlite3ent/sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the spans.
+const ScopeName = "github.com/sqlite3ent/sqlite3/otel"
+
+// Attribute keys set on the spans besides db.system=sqlite.
+const (
+	StatementKey    = attribute.Key("db.statement")
+	OperationKey    = attribute.Key("db.operation")
+	RowsAffectedKey = attribute.Key("db.rows_affected")
+	RowsReturnedKey = attribute.Key("db.rows_returned")
+	JournalModeKey  = attribute.Key("sqlite.journal_mode")
+	CommittedKey    = attribute.Key("sqlite.tx.committed")
+	BusyCountKey    = attribute.Key("sqlite.busy.count")
+	BusyWaitKey     = attribute.Key("sqlite.busy.wait_ms")
+)
+
+var systemAttr = attribute.String("db.system", "sqlite")
+
+var (
+	_ sqlite3.ConnectTracer = (*Tracer)(nil)
+	_ sqlite3.TxTracer      = (*Tracer)(nil)
+	_ sqlite3.RowsTracer    = (*Tracer)(nil)
+	_ sqlite3.BusyTracer    = (*Tracer)(nil)
+)
+
+// Tracer is a sqlite3.Tracer that emits OpenTelemetry spans named
+// "sqlite.connect", "sqlite.prepare", "sqlite.exec", "sqlite.query",
+// "sqlite.rows", "sqlite.tx", "sqlite.begin", "sqlite.commit" and
+// "sqlite.rollback". Waits for a locked database are recorded as
+// "sqlite.busy" events on the span of the statement that waited.
+type Tracer struct {
+	tracer trace.Tracer
+	// journalModes are the journal modes of the connections at connect,
+	// by weak pointer so that closed connections can be collected.
+	journalModes sync.Map // weak.Pointer[sqlite3.SQLiteConn] -> string
+}
+
+// Option configures a Tracer.
+type Option func(*config)
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// WithTracerProvider sets the TracerProvider spans are created with. It
+// defaults to the global one.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = tp
+	}
+}
+
+// NewTracer returns a Tracer configured with opts.
+func NewTracer(opts ...Option) *Tracer {
+	c := config{provider: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &Tracer{tracer: c.provider.Tracer(ScopeName)}
+}
+
+// OnConnect implements sqlite3.ConnectTracer.
+func (t *Tracer) OnConnect(ctx context.Context, conn *sqlite3.SQLiteConn, duration time.Duration, err error) {
+	if conn != nil {
+		if mode, err := conn.JournalMode(); err == nil {
+			key := weak.Make(conn)
+			t.journalModes.Store(key, mode)
+			runtime.AddCleanup(conn, func(key weak.Pointer[sqlite3.SQLiteConn]) {
+				t.journalModes.Delete(key)
+			}, key)
+		}
+	}
+	_, span := t.start(ctx, "sqlite.connect", time.Now().Add(-duration), conn)
+	end(span, err)
+}
+
+// OnQueryStart implements sqlite3.Tracer.
+func (t *Tracer) OnQueryStart(ctx context.Context, query string, args []driver.NamedValue) context.Context {
+	op := sqlite3.OperationFromContext(ctx)
+	ctx, span := t.start(ctx, "sqlite."+string(op), time.Now(), sqlite3.ConnFromContext(ctx))
+	span.SetAttributes(StatementKey.String(Sanitize(query)), OperationKey.String(sqlite3.Verb(query)))
+	return ctx
+}
+
+// OnQueryEnd implements sqlite3.Tracer.
+func (t *Tracer) OnQueryEnd(ctx context.Context, duration time.Duration, rowsAffected int64, err error) {
+	span := trace.SpanFromContext(ctx)
+	if rowsAffected >= 0 {
+		span.SetAttributes(RowsAffectedKey.Int64(rowsAffected))
+	}
+	end(span, err)
+}
+
+// OnTxStart implements sqlite3.TxTracer.
+func (t *Tracer) OnTxStart(ctx context.Context, opts driver.TxOptions) context.Context {
+	ctx, _ = t.start(ctx, "sqlite.tx", time.Now(), sqlite3.ConnFromContext(ctx))
+	return ctx
+}
+
+// OnTxEnd implements sqlite3.TxTracer.
+func (t *Tracer) OnTxEnd(ctx context.Context, duration time.Duration, committed bool, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(CommittedKey.Bool(committed))
+	end(span, err)
+}
+
+// OnRowsEnd implements sqlite3.RowsTracer.
+func (t *Tracer) OnRowsEnd(ctx context.Context, duration time.Duration, rows int64, err error) {
+	_, span := t.start(ctx, "sqlite.rows", time.Now().Add(-duration), sqlite3.ConnFromContext(ctx))
+	span.SetAttributes(RowsReturnedKey.Int64(rows))
+	end(span, err)
+}
+
+// OnBusy implements sqlite3.BusyTracer.
+func (t *Tracer) OnBusy(ctx context.Context, count int, wait time.Duration) {
+	trace.SpanFromContext(ctx).AddEvent("sqlite.busy", trace.WithAttributes(
+		BusyCountKey.Int(count),
+		BusyWaitKey.Float64(float64(wait)/float64(time.Millisecond)),
+	))
+}
+
+// start starts the span name of an operation of conn, if known.
+func (t *Tracer) start(ctx context.Context, name string, start time.Time, conn *sqlite3.SQLiteConn) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{systemAttr}
+	if conn != nil {
+		if mode, ok := t.journalModes.Load(weak.Make(conn)); ok {
+			attrs = append(attrs, JournalModeKey.String(mode.(string)))
+		}
+	}
+	return t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,135 @@
+package otel
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqlite3ent/sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	c := sqlite3.NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal=WAL")
+	c.Tracer = NewTracer(WithTracerProvider(tp))
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO test (name) VALUES ('secret')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT name FROM test WHERE id > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	root.End()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	for _, name := range []string{"sqlite.connect", "sqlite.exec", "sqlite.tx", "sqlite.begin", "sqlite.commit", "sqlite.query", "sqlite.rows"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("expected a %s span, got %v", name, exporter.GetSpans())
+		}
+	}
+
+	query := spans["sqlite.query"]
+	if query.Parent.SpanID() != root.SpanContext().SpanID() {
+		t.Fatal("expected the query span to be a child of the span of its context")
+	}
+	if begin := spans["sqlite.begin"]; begin.Parent.SpanID() != spans["sqlite.tx"].SpanContext.SpanID() {
+		t.Fatal("expected the begin span to be a child of the transaction span")
+	}
+	attrs := attribute.NewSet(query.Attributes...)
+	for key, want := range map[attribute.Key]string{
+		"db.system":    "sqlite",
+		StatementKey:   "SELECT name FROM test WHERE id > ?",
+		OperationKey:   "SELECT",
+		JournalModeKey: "wal",
+	} {
+		if v, _ := attrs.Value(key); v.AsString() != want {
+			t.Fatalf("expected %s to be %q, but got %q", key, want, v.AsString())
+		}
+	}
+	rowsAttrs := attribute.NewSet(spans["sqlite.rows"].Attributes...)
+	if v, _ := rowsAttrs.Value(RowsReturnedKey); v.AsInt64() != 1 {
+		t.Fatalf("expected 1 row returned, but got %d", v.AsInt64())
+	}
+}
+
+func TestTracerJournalModes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	// One tracer serves databases with different journal modes.
+	tracer := NewTracer(WithTracerProvider(tp))
+	dir := t.TempDir()
+	modes := []string{"wal", "delete"}
+	dbs := make([]*sql.DB, len(modes))
+	for i, mode := range modes {
+		c := sqlite3.NewConnector("file:" + filepath.Join(dir, mode+".db") + "?_journal=" + mode)
+		c.Tracer = tracer
+		dbs[i] = sql.OpenDB(c)
+		defer dbs[i].Close()
+		if err := dbs[i].Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, db := range dbs {
+		if _, err := db.ExecContext(context.Background(), `SELECT ?`, modes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, s := range exporter.GetSpans() {
+		if s.Name != "sqlite.exec" {
+			continue
+		}
+		attrs := attribute.NewSet(s.Attributes...)
+		v, _ := attrs.Value(JournalModeKey)
+		got = append(got, v.AsString())
+	}
+	if len(got) != 2 || got[0] != "wal" || got[1] != "delete" {
+		t.Fatalf("expected the journal modes [wal delete], but got %q", got)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT * FROM t WHERE a = 'it''s' AND b = 42`, `SELECT * FROM t WHERE a = ? AND b = ?`},
+		{`INSERT INTO "t1" (c2) VALUES (x'CAFE', -1.5e+3, 0x1F)`, `INSERT INTO "t1" (c2) VALUES (?, -?, ?)`},
+		{`SELECT c1 FROM t2 WHERE c1 = ?1 OR c1 = $2 -- 'note'`, `SELECT c1 FROM t2 WHERE c1 = ?1 OR c1 = $2 -- 'note'`},
+		{"SELECT [a 1], `b'2` FROM t", "SELECT [a 1], `b'2` FROM t"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.query); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
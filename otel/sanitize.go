@@ -0,0 +1,116 @@
+package otel
+
+import "strings"
+
+// Sanitize replaces the string, blob and numeric literals in query with '?',
+// so that statement text can be recorded without the values it carries.
+// Identifiers, including quoted ones, parameters and comments are kept.
+func Sanitize(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			b.WriteByte('?')
+		case (c == 'x' || c == 'X') && i+1 < len(query) && query[i+1] == '\'' && !isIdent(query, i-1):
+			i = skipQuoted(query, i+1, '\'')
+			b.WriteByte('?')
+		case c == '"' || c == '`':
+			j := skipQuoted(query, i, c)
+			b.WriteString(query[i:j])
+			i = j
+		case c == '[':
+			j := strings.IndexByte(query[i:], ']')
+			if j < 0 {
+				j = len(query) - i - 1
+			}
+			b.WriteString(query[i : i+j+1])
+			i += j + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			b.WriteString(query[i : i+j])
+			i += j
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i - 4
+			}
+			b.WriteString(query[i : i+j+4])
+			i += j + 4
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]):
+			if isIdent(query, i-1) || (i > 0 && (query[i-1] == '?' || query[i-1] == '$' || query[i-1] == ':' || query[i-1] == '@')) {
+				// Part of an identifier or of a numbered parameter.
+				for i < len(query) && isDigit(query[i]) {
+					b.WriteByte(query[i])
+					i++
+				}
+				continue
+			}
+			i = skipNumber(query, i)
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns the index after the quoted token starting at query[i],
+// where a doubled quote stands for the quote itself.
+func skipQuoted(query string, i int, quote byte) int {
+	for i++; i < len(query); i++ {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipNumber returns the index after the numeric literal starting at query[i].
+func skipNumber(query string, i int) int {
+	if strings.HasPrefix(query[i:], "0x") || strings.HasPrefix(query[i:], "0X") {
+		for i += 2; i < len(query) && isHex(query[i]); i++ {
+		}
+		return i
+	}
+	for ; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case isDigit(c), c == '.', c == '_':
+		case (c == 'e' || c == 'E') && i+1 < len(query):
+			if query[i+1] == '+' || query[i+1] == '-' {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// isIdent reports whether query[i] is part of an identifier.
+func isIdent(query string, i int) bool {
+	if i < 0 {
+		return false
+	}
+	c := query[i]
+	return c == '_' || isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
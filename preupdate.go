@@ -0,0 +1,133 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var preUpdateConns = struct {
+	sync.RWMutex
+	m map[uintptr]func(PreUpdateData)
+}{m: map[uintptr]func(PreUpdateData){}}
+
+// PreUpdateData describes a row about to be inserted, updated or deleted,
+// passed to the hook of RegisterPreUpdateHook. Its methods are only valid
+// during the call of the hook.
+type PreUpdateData struct {
+	Op       ChangeOp
+	Database string // e.g. "main"
+	Table    string
+	// OldRowID is the rowid of the row updated or deleted, NewRowID the
+	// rowid of the row inserted or updated. They are undefined for
+	// WITHOUT ROWID tables.
+	OldRowID, NewRowID int64
+
+	h sqliteHandle
+}
+
+// Count returns the number of columns of the row.
+func (d PreUpdateData) Count() int {
+	return int(sqlite3.Xsqlite3_preupdate_count(d.h.tls, d.h.db))
+}
+
+// Depth returns 0 for a change made by a statement directly, 1 for a change
+// made by a trigger it fired, 2 for one made by a trigger that trigger
+// fired, and so on. Rows deleted by ON DELETE CASCADE count as changes of
+// triggers.
+func (d PreUpdateData) Depth() int {
+	return int(sqlite3.Xsqlite3_preupdate_depth(d.h.tls, d.h.db))
+}
+
+// Old returns the value of the column i of the row before an update or
+// delete.
+func (d PreUpdateData) Old(i int) (any, error) {
+	if d.Op == ChangeInsert {
+		return nil, errors.New("sqlite3: no old row for an insert")
+	}
+	return d.value(sqlite3.Xsqlite3_preupdate_old, i)
+}
+
+// New returns the value of the column i of the row after an insert or
+// update.
+func (d PreUpdateData) New(i int) (any, error) {
+	if d.Op == ChangeDelete {
+		return nil, errors.New("sqlite3: no new row for a delete")
+	}
+	return d.value(sqlite3.Xsqlite3_preupdate_new, i)
+}
+
+func (d PreUpdateData) value(get func(tls *libc.TLS, db uintptr, iIdx int32, ppValue uintptr) int32, i int) (any, error) {
+	if i < 0 || i >= d.Count() {
+		return nil, fmt.Errorf("sqlite3: column %d out of range", i)
+	}
+	pv := d.h.tls.Alloc(8)
+	defer d.h.tls.Free(8)
+	if rc := get(d.h.tls, d.h.db, int32(i), pv); rc != sqlite3.SQLITE_OK {
+		return nil, d.h.errorOf(rc)
+	}
+	return goValue(d.h.tls, *(*uintptr)(ptr(pv))), nil
+}
+
+// RegisterPreUpdateHook makes the connection call fn before every row it
+// inserts, updates or deletes in a rowid table, including the changes of
+// triggers and foreign key actions, with the old and new values of the row,
+// see https://sqlite.org/c3ref/preupdate_blobwrite.html. fn runs on the
+// goroutine running the statement and must not use the connection.
+//
+// Sessions rely on the same hook, so a connection cannot have both. A nil
+// fn removes the hook. A hook set by the Connector is installed after the
+// PRAGMAs of the DSN ran.
+func (c *SQLiteConn) RegisterPreUpdateHook(fn func(PreUpdateData)) error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	if fn == nil {
+		sqlite3.Xsqlite3_preupdate_hook(h.tls, h.db, 0, 0)
+		c.clearPreUpdateHook()
+		return nil
+	}
+	if len(c.sessions) > 0 {
+		return errors.New("sqlite3: pre-update hook cannot be registered while a session is open")
+	}
+	preUpdateConns.Lock()
+	preUpdateConns.m[h.db] = fn
+	preUpdateConns.Unlock()
+	sqlite3.Xsqlite3_preupdate_hook(h.tls, h.db, cFuncPointer(preUpdateHook), h.db)
+	c.preUpdateDB = h.db
+	return nil
+}
+
+// clearPreUpdateHook forgets the pre-update hook of a connection being
+// closed.
+func (c *SQLiteConn) clearPreUpdateHook() {
+	if c.preUpdateDB == 0 {
+		return
+	}
+	preUpdateConns.Lock()
+	delete(preUpdateConns.m, c.preUpdateDB)
+	preUpdateConns.Unlock()
+	c.preUpdateDB = 0
+}
+
+func preUpdateHook(tls *libc.TLS, pCtx, db uintptr, op int32, zDb, zTab uintptr, iKey1, iKey2 int64) {
+	preUpdateConns.RLock()
+	fn := preUpdateConns.m[pCtx]
+	preUpdateConns.RUnlock()
+	if fn == nil {
+		return
+	}
+	fn(PreUpdateData{
+		Op:       ChangeOp(op),
+		Database: libc.GoString(zDb),
+		Table:    libc.GoString(zTab),
+		OldRowID: iKey1,
+		NewRowID: iKey2,
+		h:        sqliteHandle{db: db, tls: tls},
+	})
+}
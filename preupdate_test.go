@@ -0,0 +1,101 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestPreUpdateHook(t *testing.T) {
+	type event struct {
+		op       ChangeOp
+		table    string
+		old, new []any
+		depth    int
+	}
+	var events []event
+	c := NewConnector("file:preupdate?mode=memory&cache=shared&_fk=1")
+	c.PreUpdateHook = func(d PreUpdateData) {
+		e := event{op: d.Op, table: d.Table, depth: d.Depth()}
+		for i := range d.Count() {
+			if d.Op != ChangeInsert {
+				v, err := d.Old(i)
+				if err != nil {
+					t.Error(err)
+				}
+				e.old = append(e.old, v)
+			}
+			if d.Op != ChangeDelete {
+				v, err := d.New(i)
+				if err != nil {
+					t.Error(err)
+				}
+				e.new = append(e.new, v)
+			}
+		}
+		if _, err := d.Old(d.Count()); err == nil {
+			t.Error("expected a column out of range to fail")
+		}
+		if d.Op == ChangeInsert {
+			if _, err := d.Old(0); err == nil {
+				t.Error("expected the old row of an insert to fail")
+			}
+		}
+		events = append(events, e)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id) ON DELETE CASCADE, body BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO users VALUES (1, 'foo', 1.5);
+		INSERT INTO posts VALUES (10, 1, x'0102');
+		UPDATE users SET name = 'bar' WHERE id = 1;
+		DELETE FROM users WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	want := []event{
+		{op: ChangeInsert, table: "users", new: []any{int64(1), "foo", 1.5}},
+		{op: ChangeInsert, table: "posts", new: []any{int64(10), int64(1), []byte{1, 2}}},
+		{op: ChangeUpdate, table: "users", old: []any{int64(1), "foo", 1.5}, new: []any{int64(1), "bar", 1.5}},
+		{op: ChangeDelete, table: "users", old: []any{int64(1), "bar", 1.5}},
+		{op: ChangeDelete, table: "posts", old: []any{int64(10), int64(1), []byte{1, 2}}, depth: 1},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected %v, but got %v", want, events)
+	}
+
+	// Sessions need the hook for themselves.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc any) error {
+		sc := dc.(*SQLiteConn)
+		if _, err := sc.CreateSession(); err == nil {
+			t.Error("expected a session on a connection with a pre-update hook to fail")
+		}
+		if err := sc.RegisterPreUpdateHook(nil); err != nil {
+			return err
+		}
+		s, err := sc.CreateSession()
+		if err != nil {
+			return err
+		}
+		if err := sc.RegisterPreUpdateHook(func(PreUpdateData) {}); err == nil {
+			t.Error("expected a pre-update hook on a connection with a session to fail")
+		}
+		return s.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
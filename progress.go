@@ -0,0 +1,62 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var progressConns = struct {
+	sync.RWMutex
+	m map[uintptr]func() bool
+}{m: map[uintptr]func() bool{}}
+
+// SetProgressHandler makes the connection call fn about every nOps virtual
+// machine instructions of the statements it runs. If fn returns true, the
+// running statement is interrupted and fails with SQLITE_INTERRUPT. fn runs
+// on the goroutine running the statement and must not use the connection.
+//
+// A nil fn or an nOps below 1 removes the handler. A handler set by the
+// Connector is installed after the PRAGMAs of the DSN ran, so they are not
+// reported to it.
+func (c *SQLiteConn) SetProgressHandler(nOps int, fn func() bool) error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	if fn == nil || nOps < 1 {
+		sqlite3.Xsqlite3_progress_handler(h.tls, h.db, 0, 0, 0)
+		c.clearProgressHandler()
+		return nil
+	}
+	progressConns.Lock()
+	progressConns.m[h.db] = fn
+	progressConns.Unlock()
+	sqlite3.Xsqlite3_progress_handler(h.tls, h.db, int32(nOps), cFuncPointer(progressHandler), h.db)
+	c.progressDB = h.db
+	return nil
+}
+
+// clearProgressHandler forgets the progress handler of a connection being
+// closed.
+func (c *SQLiteConn) clearProgressHandler() {
+	if c.progressDB == 0 {
+		return
+	}
+	progressConns.Lock()
+	delete(progressConns.m, c.progressDB)
+	progressConns.Unlock()
+	c.progressDB = 0
+}
+
+func progressHandler(tls *libc.TLS, db uintptr) int32 {
+	progressConns.RLock()
+	fn := progressConns.m[db]
+	progressConns.RUnlock()
+	if fn != nil && fn() {
+		return 1
+	}
+	return 0
+}
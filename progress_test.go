@@ -0,0 +1,56 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestProgressHandler(t *testing.T) {
+	var calls atomic.Int64
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db"))
+	c.ProgressOps = 100
+	c.ProgressHandler = func() bool {
+		// Interrupt statements past a budget of 100 calls.
+		return calls.Add(1) > 100
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT 1`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+endless+`)`).Scan(&n)
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) || serr.Code() != sqlite3.SQLITE_INTERRUPT {
+		t.Fatalf("expected SQLITE_INTERRUPT, but got %v", err)
+	}
+
+	// Remove the handler on the connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).SetProgressHandler(0, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := calls.Load()
+	if err := conn.QueryRowContext(ctx, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 10000) SELECT COUNT(*) FROM n`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != before {
+		t.Fatal("expected the removed handler not to be called")
+	}
+}
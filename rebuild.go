@@ -0,0 +1,217 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// createTableRE matches the start of a CREATE TABLE statement up to the name
+// of its table.
+var createTableRE = regexp.MustCompile("(?is)^\\s*CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?(\"(?:[^\"]|\"\")*\"|`[^`]*`|\\[[^\\]]*\\]|[^\\s(]+)")
+
+// RebuildTable changes the definition of table to the CREATE TABLE statement
+// newDDL, following the generalized ALTER TABLE procedure of
+// https://sqlite.org/lang_altertable.html#otheralter: it creates the new
+// table under a temporary name, copies the rows, drops the old table, renames
+// the new one and recreates the indexes and triggers of the old table.
+//
+// The columns of the new table are copied from the columns of the same name
+// of the old one. columnMap maps the other columns, or overrides the copy,
+// to SQL expressions over the columns of the old table, e.g.
+// {"full_name": "first || ' ' || last"}. Columns left out get their default.
+// The indexes and triggers of the old table must still be valid on the new
+// one. table is matched case-insensitively, like SQLite does, and the
+// rebuilt table takes the name of newDDL.
+//
+// The rebuild runs in a savepoint of conn. When foreign keys are enforced,
+// e.g. with _fk=1, they are turned off for the rebuild, which therefore has
+// to start outside a transaction, then checked with foreign_key_check before
+// it commits, and turned back on.
+func RebuildTable(ctx context.Context, conn *sql.Conn, table, newDDL string, columnMap map[string]string) (err error) {
+	m := createTableRE.FindStringSubmatchIndex(newDDL)
+	if m == nil || !strings.EqualFold(unquoteIdent(newDDL[m[2]:m[3]]), table) {
+		return fmt.Errorf("sqlite3: rebuild %s: expecting a CREATE TABLE %s statement", table, table)
+	}
+	newName := unquoteIdent(newDDL[m[2]:m[3]])
+
+	fk, restore, err := foreignKeysOff(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("sqlite3: rebuild %s: %w", table, err)
+	}
+	defer func() {
+		if rerr := restore(); err == nil {
+			err = rerr
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT rebuild_table"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("sqlite3: rebuild %s: %w", table, err)
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK TO rebuild_table")
+		}
+		if _, rerr := conn.ExecContext(context.Background(), "RELEASE rebuild_table"); err == nil {
+			err = rerr
+		}
+	}()
+
+	// Table names are case-insensitive: the rest uses the name the table
+	// was created with.
+	var name string
+	err = conn.QueryRowContext(ctx, `SELECT name FROM sqlite_schema WHERE type = 'table' AND name = ? COLLATE NOCASE`, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no such table")
+	}
+	if err != nil {
+		return err
+	}
+	tmp := "rebuild_" + name
+	ddl := newDDL[:m[2]] + quoteIdent(tmp) + newDDL[m[3]:]
+
+	var schema []string
+	err = queryEach(ctx, conn, func(rows *sql.Rows) error {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return err
+		}
+		schema = append(schema, s)
+		return nil
+	}, `SELECT sql FROM sqlite_schema WHERE tbl_name = ? COLLATE NOCASE AND type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY type, rowid`, name)
+	if err != nil {
+		return err
+	}
+	old := map[string]bool{}
+	err = queryEach(ctx, conn, func(rows *sql.Rows) error {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return err
+		}
+		old[strings.ToLower(col)] = true
+		return nil
+	}, `SELECT name FROM pragma_table_xinfo(?)`, name)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, ddl); err != nil {
+		return err
+	}
+	var cols, exprs []string
+	err = queryEach(ctx, conn, func(rows *sql.Rows) error {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return err
+		}
+		expr, ok := columnMap[col]
+		if !ok && old[strings.ToLower(col)] {
+			expr, ok = quoteIdent(col), true
+		}
+		if ok {
+			cols, exprs = append(cols, quoteIdent(col)), append(exprs, expr)
+		}
+		return nil
+	}, `SELECT name FROM pragma_table_xinfo(?) WHERE hidden NOT IN (2, 3) ORDER BY cid`, tmp)
+	if err != nil {
+		return err
+	}
+	if len(cols) > 0 {
+		copyRows := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteIdent(tmp), strings.Join(cols, ", "), strings.Join(exprs, ", "), quoteIdent(name))
+		if _, err := conn.ExecContext(ctx, copyRows); err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "DROP TABLE "+quoteIdent(name)); err != nil {
+		return err
+	}
+	// The legacy rename leaves the views and triggers referring to table
+	// alone instead of failing on them while it does not exist.
+	if _, err := conn.ExecContext(ctx, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(tmp), quoteIdent(newName)))
+	if _, lerr := conn.ExecContext(ctx, "PRAGMA legacy_alter_table = OFF"); err == nil {
+		err = lerr
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range schema {
+		if _, err := conn.ExecContext(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	if fk {
+		return foreignKeyCheck(ctx, conn)
+	}
+	return nil
+}
+
+// unquoteIdent returns the identifier name without its quotes, if any.
+func unquoteIdent(name string) string {
+	if len(name) < 2 {
+		return name
+	}
+	switch name[0] {
+	case '"':
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	case '`', '[':
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// foreignKeysOff turns off foreign keys on conn if they are on, e.g. with
+// _fk=1, which must happen outside a transaction. It reports whether they
+// were on and returns the function turning them back on.
+func foreignKeysOff(ctx context.Context, conn *sql.Conn) (bool, func() error, error) {
+	var fk int64
+	inTx := false
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(*SQLiteConn)
+		if !ok {
+			return fmt.Errorf("sqlite3: unexpected connection type %T", dc)
+		}
+		inTx = c.inTx()
+		return c.queryRow(ctx, "PRAGMA foreign_keys", &fk)
+	})
+	if err != nil || fk == 0 {
+		return false, func() error { return nil }, err
+	}
+	if inTx {
+		return false, nil, errors.New("foreign keys cannot be turned off inside a transaction")
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return false, nil, err
+	}
+	return true, func() error {
+		_, err := conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+		return err
+	}, nil
+}
+
+// foreignKeyCheck returns an error describing the first row of q violating
+// a foreign key constraint, if any.
+func foreignKeyCheck(ctx context.Context, q queryer) error {
+	var violation error
+	err := queryEach(ctx, q, func(rows *sql.Rows) error {
+		var child, parent string
+		var rowid sql.NullInt64
+		if err := rows.Scan(&child, &rowid, &parent); err != nil {
+			return err
+		}
+		violation = fmt.Errorf("foreign key violation: %s row %d references a missing %s row", child, rowid.Int64, parent)
+		return nil
+	}, `SELECT "table", rowid, parent FROM pragma_foreign_key_check LIMIT 1`)
+	if err != nil {
+		return err
+	}
+	return violation
+}
@@ -0,0 +1,163 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRebuildTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, first TEXT, last TEXT, age TEXT CHECK (age <> ''))`,
+		`CREATE INDEX users_last ON users (last)`,
+		`CREATE TABLE log (msg TEXT)`,
+		`CREATE TRIGGER users_insert AFTER INSERT ON users BEGIN INSERT INTO log VALUES ('insert ' || new.id); END`,
+		`CREATE TABLE pets (id INTEGER PRIMARY KEY, owner INTEGER REFERENCES users ON DELETE CASCADE)`,
+		`CREATE VIEW names AS SELECT first FROM users`,
+		`INSERT INTO users (first, last, age) VALUES ('Ada', 'Lovelace', '36'), ('Alan', 'Turing', '41')`,
+		`INSERT INTO pets (owner) VALUES (1), (2)`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Change the type of age, drop its CHECK constraint and merge the names.
+	err = RebuildTable(ctx, conn, "users", `CREATE TABLE "users" (id INTEGER PRIMARY KEY, name TEXT NOT NULL, last TEXT, age INTEGER)`, map[string]string{
+		"name": "first || ' ' || last",
+		"age":  "CAST(age AS INTEGER)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sqlText string
+	if err := conn.QueryRowContext(ctx, `SELECT sql FROM sqlite_schema WHERE name = 'users'`).Scan(&sqlText); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sqlText, `CREATE TABLE "users" (id INTEGER PRIMARY KEY, name TEXT NOT NULL`) {
+		t.Fatalf("expected the new definition, but got %s", sqlText)
+	}
+	var name string
+	var age any
+	if err := conn.QueryRowContext(ctx, `SELECT name, age FROM users WHERE id = 2`).Scan(&name, &age); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Alan Turing" || age != int64(41) {
+		t.Fatalf("expected Alan Turing aged 41, but got %s aged %v", name, age)
+	}
+	var count int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM pets`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected the pets to survive the drop of users, but got %d", count)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_schema WHERE name IN ('users_last', 'users_insert')`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("expected the index and the trigger to be recreated")
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO users (name) VALUES ('Grace Hopper')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM log`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected the trigger to run, but got %d log rows", count)
+	}
+	var fk int
+	if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&fk); err != nil {
+		t.Fatal(err)
+	}
+	if fk != 1 {
+		t.Fatal("expected foreign keys to be turned back on")
+	}
+
+	// A rebuild breaking a foreign key is rolled back.
+	err = RebuildTable(ctx, conn, "users", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, last TEXT)`, map[string]string{"id": "id + 10"})
+	if err == nil || !strings.Contains(err.Error(), "foreign key violation") {
+		t.Fatalf("expected a foreign key violation, but got %v", err)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id <= 3`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected the rebuild to be rolled back, but got %d rows", count)
+	}
+
+	if err := RebuildTable(ctx, conn, "users", `CREATE TABLE people (id INTEGER PRIMARY KEY)`, nil); err == nil {
+		t.Fatal("expected a statement creating another table to fail")
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := RebuildTable(ctx, conn, "users", `CREATE TABLE users (id INTEGER PRIMARY KEY)`, nil); err == nil {
+		t.Fatal("expected a rebuild with foreign keys on inside a transaction to fail")
+	}
+}
+
+func TestRebuildTableMixedCase(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE Items (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE INDEX items_name ON ITEMS (name)`,
+		`CREATE TABLE log (msg TEXT)`,
+		`CREATE TRIGGER items_insert AFTER INSERT ON items BEGIN INSERT INTO log VALUES (new.name); END`,
+		`INSERT INTO items (name) VALUES ('a')`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The table is named in another case than it was created with.
+	if err := RebuildTable(ctx, conn, "ITEMS", `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, size INTEGER)`, nil); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_schema WHERE name IN ('items_name', 'items_insert')`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected the index and the trigger to be recreated, but got %d of them", count)
+	}
+	var name string
+	if err := conn.QueryRowContext(ctx, `SELECT name FROM sqlite_schema WHERE type = 'table' AND name LIKE 'items'`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "items" {
+		t.Fatalf("expected the table to be named items, but got %s", name)
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM items WHERE name = 'a'`).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected the row to be copied, but got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,639 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// replicaTimeFormat formats the UTC times naming generations and segments,
+// so that they sort in time order.
+const replicaTimeFormat = "20060102T150405.000000000Z"
+
+// segmentRE matches the names of the segment files of a generation.
+var segmentRE = regexp.MustCompile(`^(\d{8})-(\d{8}T\d{6}\.\d{9}Z)\.wal$`)
+
+// errWALRestarted is returned by copyFrames when the WAL restarted before
+// all of its frames were copied.
+var errWALRestarted = errors.New("sqlite3: WAL restarted before it was replicated")
+
+// ReplicatorConfig configures a Replicator.
+type ReplicatorConfig struct {
+	// Dir is the directory of the replica, created if needed.
+	Dir string
+	// Interval is how often the new frames of the WAL are copied, every
+	// second if <= 0.
+	Interval time.Duration
+	// SnapshotInterval is how often a snapshot starts a new generation,
+	// every 24 hours if <= 0.
+	SnapshotInterval time.Duration
+	// MaxWALSize is the size of the WAL file, in bytes, above which the
+	// replicator checkpoints it once copied, 4 MiB if <= 0.
+	MaxWALSize int64
+	// Logger receives the syncs failing, slog.Default() if nil.
+	Logger *slog.Logger
+}
+
+// Replicator continuously replicates a database in WAL mode to a local
+// directory, from which RestoreFromReplica rebuilds it as of any point in
+// time covered.
+//
+// The replica is made of generations, each a directory named after the
+// time it started holding a snapshot of the database taken with NewBackup,
+// snapshot.db, followed by NNNNNNNN-<time>.wal segment files holding the
+// frames of the transactions committed since, copied from the WAL as they
+// are.
+//
+// The replicator opens its own connections with the Connector of db, so that
+// it works with pools limited to a single connection, e.g. by
+// SetMaxOpenConns(1). It keeps one of them in a read transaction, so that
+// the WAL is not restarted before it copied it, and does the checkpoints
+// restarting it itself, with CheckpointRestart. The WAL file is then written
+// over from the start rather than truncated; PRAGMA journal_size_limit
+// bounds its size. Checkpoints run by others, e.g. a Checkpointer, fail with
+// ErrCheckpointBusy or force a new generation. The held connection delays
+// SwapDatabase until Close.
+type Replicator struct {
+	db   *sql.DB // connections of the replicator, see NewReplicator
+	cfg  ReplicatorConfig
+	wal  string // path of the WAL file
+	conn *sql.Conn
+	tx   *sql.Tx // read transaction held on conn
+
+	mu        sync.Mutex // guards the fields below
+	gen       string     // directory of the current generation
+	genStart  time.Time
+	segments  int
+	hdr       walHeader // header of the WAL copied, zero if none yet
+	frames    int64     // frames of the WAL copied
+	sum       [2]uint32 // checksum of the last frame copied
+	restarted bool      // the WAL was checkpointed after all its frames were copied
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewReplicator starts replicating the main database of db, which must be a
+// file in WAL mode, e.g. opened with _journal=WAL, into cfg.Dir. It starts
+// a new generation with a snapshot.
+//
+// db must have been opened by a Connector, e.g. with sql.Open("sqlite3",
+// dsn) or sql.OpenDB. The replicator only uses it to get the Connector,
+// which opens the connections of the replicator in a pool of its own.
+func NewReplicator(ctx context.Context, db *sql.DB, cfg ReplicatorConfig) (*Replicator, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("sqlite3: replicator needs a directory")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = 24 * time.Hour
+	}
+	if cfg.MaxWALSize <= 0 {
+		cfg.MaxWALSize = 4 << 20
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var c *Connector
+	err = conn.Raw(func(dc any) error {
+		sc, ok := dc.(*SQLiteConn)
+		if !ok || sc.connector == nil {
+			return errors.New("sqlite3: replicator needs a database opened by a sqlite3.Connector")
+		}
+		c = sc.connector
+		return nil
+	})
+	_ = conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	db = sql.OpenDB(c)
+	// Close the connections of snapshots and checkpoints once done, which
+	// would delay SwapDatabase otherwise.
+	db.SetMaxIdleConns(0)
+	r, err := newReplicator(ctx, db, cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newReplicator(ctx context.Context, db *sql.DB, cfg ReplicatorConfig) (*Replicator, error) {
+	var path, mode string
+	if err := db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("sqlite3: replicator needs a database file")
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+		return nil, err
+	}
+	if mode != "wal" {
+		return nil, fmt.Errorf("sqlite3: replicator needs a database in WAL mode, not %s", mode)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replicator{db: db, cfg: cfg, wal: path + "-wal", conn: conn, done: make(chan struct{})}
+	if err := r.beginRead(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := r.snapshot(ctx); err != nil {
+		_ = r.endRead()
+		_ = conn.Close()
+		return nil, err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(runCtx)
+	return r, nil
+}
+
+// Sync copies the transactions committed since the last sync into a new
+// segment, then checkpoints the WAL if it grew above MaxWALSize.
+func (r *Replicator) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sync(ctx)
+}
+
+// Snapshot starts a new generation with a snapshot of the database.
+func (r *Replicator) Snapshot(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot(ctx)
+}
+
+// Close stops the replicator after a last sync and closes its connections.
+func (r *Replicator) Close() error {
+	var err error
+	r.once.Do(func() {
+		r.cancel()
+		<-r.done
+		err = r.Sync(context.Background())
+		if rerr := r.endRead(); err == nil {
+			err = rerr
+		}
+		if cerr := r.conn.Close(); err == nil {
+			err = cerr
+		}
+		if cerr := r.db.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+func (r *Replicator) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		err := r.sync(ctx)
+		if err == nil && time.Since(r.genStart) >= r.cfg.SnapshotInterval {
+			err = r.snapshot(ctx)
+		}
+		r.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			r.cfg.Logger.LogAttrs(ctx, slog.LevelWarn, "replication failed",
+				slog.String("dir", r.cfg.Dir), slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (r *Replicator) sync(ctx context.Context) error {
+	err := r.copyFrames(true)
+	if errors.Is(err, errWALRestarted) {
+		return r.snapshot(ctx)
+	}
+	if err != nil || r.hdr.pageSize == 0 {
+		return err
+	}
+	if walHeaderSize+r.frames*int64(walFrameHeaderSize+r.hdr.pageSize) <= r.cfg.MaxWALSize {
+		return nil
+	}
+
+	if err := r.endRead(); err != nil {
+		return err
+	}
+	log, _, cerr := Checkpoint(ctx, r.db, CheckpointRestart)
+	if err := r.beginRead(ctx); err != nil {
+		return err
+	}
+	switch {
+	case errors.Is(cerr, ErrCheckpointBusy):
+		return nil // tried again on the next sync
+	case cerr != nil:
+		return cerr
+	case int64(log) != r.frames:
+		// Transactions committed since the copy were checkpointed and are
+		// gone from the WAL.
+		return r.snapshot(ctx)
+	}
+	r.restarted = true
+	return nil
+}
+
+// snapshot starts a new generation, holding the write lock of the database
+// while it copies the last frames into the current one and takes the
+// snapshot.
+func (r *Replicator) snapshot(ctx context.Context) (err error) {
+	w, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err := w.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	defer func() {
+		if _, rerr := w.ExecContext(context.Background(), "ROLLBACK"); err == nil {
+			err = rerr
+		}
+	}()
+	if r.gen != "" {
+		if err := r.copyFrames(true); err != nil && !errors.Is(err, errWALRestarted) {
+			return err
+		}
+	}
+
+	start := time.Now().UTC()
+	gen := filepath.Join(r.cfg.Dir, start.Format(replicaTimeFormat))
+	if err := os.Mkdir(gen, 0o755); err != nil {
+		return err
+	}
+	if err := r.endRead(); err != nil {
+		return err
+	}
+	tmp := filepath.Join(gen, "snapshot.db.tmp")
+	err = r.conn.Raw(func(dc any) error {
+		c, ok := dc.(*SQLiteConn)
+		if !ok {
+			return fmt.Errorf("sqlite3: unexpected connection type %T", dc)
+		}
+		b, err := c.NewBackup("file:" + tmp)
+		if err != nil {
+			return err
+		}
+		for more := true; more && err == nil; {
+			more, err = b.Step(-1)
+		}
+		if ferr := b.Finish(); err == nil {
+			err = ferr
+		}
+		return err
+	})
+	if rerr := r.beginRead(ctx); err == nil {
+		err = rerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(gen, "snapshot.db"))
+	}
+	if err != nil {
+		_ = os.RemoveAll(gen)
+		return err
+	}
+
+	// The snapshot holds every frame of the WAL, which is not written while
+	// the write lock is held: the generation starts at its end.
+	r.gen, r.genStart, r.segments = gen, start, 0
+	r.hdr, r.frames, r.sum, r.restarted = walHeader{}, 0, [2]uint32{}, false
+	return r.copyFrames(false)
+}
+
+// copyFrames copies the frames of the transactions committed to the WAL
+// since the last copy into a new segment of the current generation, or
+// only skips them if write is false.
+func (r *Replicator) copyFrames(write bool) (err error) {
+	f, err := os.Open(r.wal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil // empty WAL
+		}
+		return err
+	}
+	h, ok := parseWALHeader(buf)
+	if !ok {
+		return nil // header being written
+	}
+	if h.salt != r.hdr.salt {
+		if r.hdr.pageSize != 0 && !(r.restarted && h.ckptSeq == r.hdr.ckptSeq+1) {
+			return errWALRestarted
+		}
+		r.hdr, r.frames, r.sum, r.restarted = h, 0, h.sum, false
+	}
+
+	frameSize := int64(walFrameHeaderSize + h.pageSize)
+	if _, err := f.Seek(walHeaderSize+r.frames*frameSize, io.SeekStart); err != nil {
+		return err
+	}
+	var out *os.File
+	tmp := filepath.Join(r.gen, "segment.tmp")
+	defer func() {
+		if out != nil {
+			_ = out.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	frame := make([]byte, frameSize)
+	frames, sum := r.frames, r.sum
+	committed, committedSum := frames, sum
+	for {
+		if _, err := io.ReadFull(f, frame); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		if [8]byte(frame[8:16]) != h.salt {
+			break // frame of an older WAL
+		}
+		sum = walChecksum(h.bigEndian, sum, frame[:8])
+		sum = walChecksum(h.bigEndian, sum, frame[walFrameHeaderSize:])
+		if sum != [2]uint32{binary.BigEndian.Uint32(frame[16:]), binary.BigEndian.Uint32(frame[20:])} {
+			break // frame left by a rolled back transaction, or being written
+		}
+		if write {
+			if out == nil {
+				if out, err = os.Create(tmp); err != nil {
+					return err
+				}
+			}
+			if _, err := out.Write(frame); err != nil {
+				return err
+			}
+		}
+		frames++
+		if binary.BigEndian.Uint32(frame[4:]) != 0 { // commit frame
+			committed, committedSum = frames, sum
+		}
+	}
+	if committed == r.frames {
+		return nil
+	}
+
+	if out != nil {
+		// Frames after the last commit frame belong to a transaction in
+		// progress, copied with the next segment.
+		err := out.Truncate((committed - r.frames) * frameSize)
+		if err == nil {
+			err = out.Sync()
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		out = nil
+		if err == nil {
+			name := fmt.Sprintf("%08d-%s.wal", r.segments+1, time.Now().UTC().Format(replicaTimeFormat))
+			err = os.Rename(tmp, filepath.Join(r.gen, name))
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		r.segments++
+	}
+	r.frames, r.sum = committed, committedSum
+	return nil
+}
+
+// beginRead opens the read transaction held on the connection of r.
+func (r *Replicator) beginRead(ctx context.Context) error {
+	tx, err := r.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_schema").Scan(&n); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	r.tx = tx
+	return nil
+}
+
+// endRead ends the read transaction held on the connection of r, if any.
+func (r *Replicator) endRead() error {
+	if r.tx == nil {
+		return nil
+	}
+	err := r.tx.Rollback()
+	r.tx = nil
+	return err
+}
+
+// RestoreFromReplica rebuilds the database replicated into dir as of
+// pointInTime, or as of the last sync if pointInTime is zero, into the file
+// path, replacing it. It applies to the snapshot of the last generation
+// started at or before pointInTime the segments copied at or before
+// pointInTime, so the transactions restored are those committed by the
+// last sync before it.
+//
+// path must not be open; SwapDatabase can move the restored file in place
+// of an open database.
+func RestoreFromReplica(dir string, pointInTime time.Time, path string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var gen string
+	for _, e := range slices.Backward(entries) {
+		t, err := time.Parse(replicaTimeFormat, e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if pointInTime.IsZero() || !t.After(pointInTime) {
+			gen = filepath.Join(dir, e.Name())
+			break
+		}
+	}
+	if gen == "" {
+		return fmt.Errorf("sqlite3: no replica in %s as of %v", dir, pointInTime)
+	}
+
+	tmp := path + ".restore"
+	if err := copyFile(filepath.Join(gen, "snapshot.db"), tmp); err != nil {
+		return err
+	}
+	err = applySegments(gen, pointInTime, tmp)
+	if err == nil {
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err = os.Remove(path + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			err = nil
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// applySegments writes the pages of the segments of the generation gen
+// copied at or before pointInTime into the database file path.
+func applySegments(gen string, pointInTime time.Time, path string) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	var hdr [100]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return err
+	}
+	pageSize := int64(binary.BigEndian.Uint16(hdr[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	entries, err := os.ReadDir(gen)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for _, e := range entries {
+		m := segmentRE.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		if t, _ := time.Parse(replicaTimeFormat, m[2]); !pointInTime.IsZero() && t.After(pointInTime) {
+			break
+		}
+		seg, err := os.Open(filepath.Join(gen, e.Name()))
+		if err != nil {
+			return err
+		}
+		for err == nil {
+			if _, err = io.ReadFull(seg, frame); err != nil {
+				break
+			}
+			pgno, commit := binary.BigEndian.Uint32(frame), binary.BigEndian.Uint32(frame[4:])
+			_, err = f.WriteAt(frame[walFrameHeaderSize:], int64(pgno-1)*pageSize)
+			if err == nil && commit != 0 {
+				err = f.Truncate(int64(commit) * pageSize)
+			}
+		}
+		_ = seg.Close()
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("sqlite3: restore %s: %w", e.Name(), err)
+		}
+	}
+	return f.Sync()
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// walHeader is the header of a WAL file, see
+// https://sqlite.org/fileformat2.html#walformat.
+type walHeader struct {
+	bigEndian bool // byte order of the checksums
+	pageSize  int
+	ckptSeq   uint32
+	salt      [8]byte
+	sum       [2]uint32
+}
+
+// parseWALHeader parses the header b of a WAL file and reports whether it
+// is valid.
+func parseWALHeader(b []byte) (walHeader, bool) {
+	magic := binary.BigEndian.Uint32(b)
+	if magic&^1 != 0x377f0682 {
+		return walHeader{}, false
+	}
+	h := walHeader{
+		bigEndian: magic&1 == 1,
+		pageSize:  int(binary.BigEndian.Uint32(b[8:])),
+		ckptSeq:   binary.BigEndian.Uint32(b[12:]),
+		salt:      [8]byte(b[16:24]),
+	}
+	h.sum = walChecksum(h.bigEndian, [2]uint32{}, b[:24])
+	if h.sum != [2]uint32{binary.BigEndian.Uint32(b[24:]), binary.BigEndian.Uint32(b[28:])} {
+		return walHeader{}, false
+	}
+	return h, true
+}
+
+// walChecksum continues the checksum s of a WAL over b.
+func walChecksum(bigEndian bool, s [2]uint32, b []byte) [2]uint32 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s[0] += order.Uint32(b[i:]) + s[1]
+		s[1] += order.Uint32(b[i+4:]) + s[0]
+	}
+	return s
+}
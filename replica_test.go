@@ -0,0 +1,192 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplicator(t *testing.T) {
+	dir := t.TempDir()
+	file, replica := filepath.Join(dir, "test.db"), filepath.Join(dir, "replica")
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:"+file+"?_journal=WAL&_busy_timeout=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, data BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReplicator(ctx, db, ReplicatorConfig{Dir: replica, Interval: time.Hour, MaxWALSize: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// insert adds n rows, syncs and returns the time after the sync.
+	insert := func(n int) time.Time {
+		t.Helper()
+		if _, err := db.ExecContext(ctx, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?) INSERT INTO test (data) SELECT randomblob(100) FROM n`, n); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return time.Now()
+	}
+	// restored returns the number of rows of the database restored as of at.
+	restored := func(at time.Time) int64 {
+		t.Helper()
+		path := filepath.Join(dir, "restored.db")
+		if err := RestoreFromReplica(replica, at, path); err != nil {
+			t.Fatal(err)
+		}
+		rdb, err := sql.Open("sqlite3", "file:"+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rdb.Close()
+		var check string
+		if err := rdb.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&check); err != nil || check != "ok" {
+			t.Fatalf("expected a restored database passing the integrity check, but got %s, %v", check, err)
+		}
+		var n int64
+		if err := rdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	generations := func() int {
+		t.Helper()
+		entries, err := os.ReadDir(replica)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	t0 := time.Now()
+	t1 := insert(10)
+	t2 := insert(20)
+	// The WAL grows above MaxWALSize, so the sync checkpoints it and the
+	// next one continues on the restarted WAL.
+	t3 := insert(1000)
+	t4 := insert(5)
+	if log, _, err := Checkpoint(ctx, db, CheckpointPassive); err != nil || log > 5 {
+		t.Fatalf("expected the WAL to be restarted, but got %d frames, %v", log, err)
+	}
+	if n := generations(); n != 1 {
+		t.Fatalf("expected 1 generation, but got %d", n)
+	}
+
+	// The replicator holds the WAL, other checkpoints cannot restart it.
+	if _, _, err := Checkpoint(ctx, db, CheckpointTruncate); !errors.Is(err, ErrCheckpointBusy) {
+		t.Fatalf("expected ErrCheckpointBusy, but got %v", err)
+	}
+
+	if err := r.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t5 := insert(1)
+	if n := generations(); n != 2 {
+		t.Fatalf("expected 2 generations, but got %d", n)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO test (data) VALUES (NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		at   time.Time
+		want int64
+	}{
+		{t0, 0},
+		{t1, 10},
+		{t2, 30},
+		{t3, 1030},
+		{t4, 1035},
+		{t5, 1036},
+		{time.Time{}, 1037},
+	} {
+		if n := restored(tt.at); n != tt.want {
+			t.Fatalf("expected %d rows as of %v, but got %d", tt.want, tt.at, n)
+		}
+	}
+	if err := RestoreFromReplica(replica, t0.Add(-time.Hour), filepath.Join(dir, "restored.db")); err == nil {
+		t.Fatal("expected a restore before the first snapshot to fail")
+	}
+
+	mem, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if _, err := NewReplicator(ctx, mem, ReplicatorConfig{Dir: replica}); err == nil {
+		t.Fatal("expected a replicator of an in-memory database to fail")
+	}
+}
+
+func TestReplicatorSingleConnection(t *testing.T) {
+	dir := t.TempDir()
+	file, replica := filepath.Join(dir, "test.db"), filepath.Join(dir, "replica")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := sql.Open("sqlite3", "file:"+file+"?_journal=WAL&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (n INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReplicator(ctx, db, ReplicatorConfig{Dir: replica, Interval: time.Hour, MaxWALSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := range 3 {
+		if _, err := db.ExecContext(ctx, `INSERT INTO test VALUES (?)`, i); err != nil {
+			t.Fatal(err)
+		}
+		// With MaxWALSize 1, every sync checkpoints the WAL.
+		if err := r.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO test VALUES (3)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "restored.db")
+	if err := RestoreFromReplica(replica, time.Time{}, path); err != nil {
+		t.Fatal(err)
+	}
+	rdb, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	var n int64
+	if err := rdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 rows, but got %d", n)
+	}
+}
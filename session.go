@@ -0,0 +1,333 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Session records the changes made on a connection to tables of its main
+// database, and returns them as a changeset, see
+// https://sqlite.org/sessionintro.html. Only tables with a PRIMARY KEY are
+// recorded.
+//
+// A Session belongs to the connection that created it and must not be used
+// concurrently with it. It is closed with the connection.
+type Session struct {
+	c       *SQLiteConn
+	session uintptr // *sqlite3_session
+}
+
+// CreateSession starts recording the changes made on c to tables, or to
+// every table of the main database if none is given. The Session must be
+// closed. Sessions rely on the pre-update hook, so they cannot be created
+// on a connection with a RegisterPreUpdateHook hook.
+func (c *SQLiteConn) CreateSession(tables ...string) (*Session, error) {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if c.preUpdateDB != 0 {
+		return nil, errors.New("sqlite3: session cannot be created on a connection with a pre-update hook")
+	}
+	zDb, err := libc.CString("main")
+	if err != nil {
+		return nil, err
+	}
+	defer libc.Xfree(h.tls, zDb)
+	psession := h.tls.Alloc(8)
+	defer h.tls.Free(8)
+	if rc := sqlite3.Xsqlite3session_create(h.tls, h.db, zDb, psession); rc != sqlite3.SQLITE_OK {
+		return nil, h.errorOf(rc)
+	}
+	s := &Session{c: c, session: *(*uintptr)(ptr(psession))}
+	if len(tables) == 0 {
+		tables = []string{""}
+	}
+	for _, table := range tables {
+		var zTab uintptr
+		if table != "" {
+			if zTab, err = libc.CString(table); err != nil {
+				sqlite3.Xsqlite3session_delete(h.tls, s.session)
+				return nil, err
+			}
+		}
+		rc := sqlite3.Xsqlite3session_attach(h.tls, s.session, zTab)
+		if zTab != 0 {
+			libc.Xfree(h.tls, zTab)
+		}
+		if rc != sqlite3.SQLITE_OK {
+			sqlite3.Xsqlite3session_delete(h.tls, s.session)
+			return nil, h.errorOf(rc)
+		}
+	}
+	if c.sessions == nil {
+		c.sessions = map[*Session]struct{}{}
+	}
+	c.sessions[s] = struct{}{}
+	return s, nil
+}
+
+// Changeset returns the changes recorded by s as a changeset, holding the
+// old and new values of every changed row.
+func (s *Session) Changeset() ([]byte, error) {
+	return s.output(sqlite3.Xsqlite3session_changeset)
+}
+
+// Patchset returns the changes recorded by s as a patchset, a more compact
+// changeset holding the primary key only of the deleted rows and the new
+// values only of the updated ones. A patchset cannot be inverted, and fewer
+// conflicts are detected applying it.
+func (s *Session) Patchset() ([]byte, error) {
+	return s.output(sqlite3.Xsqlite3session_patchset)
+}
+
+func (s *Session) output(fn func(tls *libc.TLS, pSession uintptr, pnOut uintptr, ppOut uintptr) int32) ([]byte, error) {
+	if s.session == 0 {
+		return nil, errors.New("sqlite3: session is closed")
+	}
+	h, err := handleOf(s.c.conn)
+	if err != nil {
+		return nil, err
+	}
+	p := h.tls.Alloc(16)
+	defer h.tls.Free(16)
+	pn, pp := p, p+8
+	if rc := fn(h.tls, s.session, pn, pp); rc != sqlite3.SQLITE_OK {
+		return nil, h.errorOf(rc)
+	}
+	return takeBuffer(h.tls, *(*uintptr)(ptr(pp)), int(*(*int32)(ptr(pn)))), nil
+}
+
+// IsEmpty reports whether s recorded no change.
+func (s *Session) IsEmpty() bool {
+	if s.session == 0 {
+		return true
+	}
+	h, err := handleOf(s.c.conn)
+	if err != nil {
+		return true
+	}
+	return sqlite3.Xsqlite3session_isempty(h.tls, s.session) != 0
+}
+
+// Close stops the recording and releases s.
+func (s *Session) Close() error {
+	if s.session == 0 {
+		return nil
+	}
+	h, err := handleOf(s.c.conn)
+	if err != nil {
+		return err
+	}
+	sqlite3.Xsqlite3session_delete(h.tls, s.session)
+	s.session = 0
+	delete(s.c.sessions, s)
+	return nil
+}
+
+// closeSessions closes the sessions of a connection being closed.
+func (c *SQLiteConn) closeSessions() {
+	for s := range c.sessions {
+		_ = s.Close()
+	}
+}
+
+// InvertChangeset returns the changeset undoing changeset: its inserts
+// become deletes and the reverse, and its updates swap their old and new
+// values.
+func InvertChangeset(changeset []byte) ([]byte, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+	in, err := cBuffer(tls, changeset)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlite3.Xsqlite3_free(tls, in)
+	p := tls.Alloc(16)
+	defer tls.Free(16)
+	pn, pp := p, p+8
+	if rc := sqlite3.Xsqlite3changeset_invert(tls, int32(len(changeset)), in, pn, pp); rc != sqlite3.SQLITE_OK {
+		return nil, &resultError{msg: libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)), code: int(rc)}
+	}
+	return takeBuffer(tls, *(*uintptr)(ptr(pp)), int(*(*int32)(ptr(pn)))), nil
+}
+
+// ConflictType is the kind of conflict ApplyChangeset hits, see
+// https://sqlite.org/session/c_changeset_conflict.html.
+type ConflictType int
+
+const (
+	// ConflictData is an update or delete of a row whose values are not
+	// the old values of the change.
+	ConflictData ConflictType = sqlite3.SQLITE_CHANGESET_DATA
+	// ConflictNotFound is an update or delete of a row that does not exist.
+	ConflictNotFound ConflictType = sqlite3.SQLITE_CHANGESET_NOTFOUND
+	// ConflictConflict is an insert of a row whose primary key exists.
+	ConflictConflict ConflictType = sqlite3.SQLITE_CHANGESET_CONFLICT
+	// ConflictConstraint is a change violating a constraint other than the
+	// primary key.
+	ConflictConstraint ConflictType = sqlite3.SQLITE_CHANGESET_CONSTRAINT
+	// ConflictForeignKey is a changeset leaving foreign key violations,
+	// reported once applied.
+	ConflictForeignKey ConflictType = sqlite3.SQLITE_CHANGESET_FOREIGN_KEY
+)
+
+// ConflictAction is the decision of a conflict handler of ApplyChangeset.
+type ConflictAction int
+
+const (
+	// ChangesetOmit skips the change.
+	ChangesetOmit ConflictAction = sqlite3.SQLITE_CHANGESET_OMIT
+	// ChangesetReplace applies the change over the conflicting row. It is
+	// only valid for ConflictData and ConflictConflict.
+	ChangesetReplace ConflictAction = sqlite3.SQLITE_CHANGESET_REPLACE
+	// ChangesetAbort rolls back the changes applied and fails
+	// ApplyChangeset.
+	ChangesetAbort ConflictAction = sqlite3.SQLITE_CHANGESET_ABORT
+)
+
+// ChangeOp is the operation of a Change.
+type ChangeOp int
+
+const (
+	ChangeInsert ChangeOp = sqlite3.SQLITE_INSERT
+	ChangeUpdate ChangeOp = sqlite3.SQLITE_UPDATE
+	ChangeDelete ChangeOp = sqlite3.SQLITE_DELETE
+)
+
+// Change is a change of a changeset, as seen by a conflict handler.
+type Change struct {
+	Table string
+	Op    ChangeOp
+	// Old are the old values of an update or delete, New the new values of
+	// an insert or update; the values of the columns an update leaves
+	// alone are nil. Conflicting are the values of the row in the database
+	// for ConflictData and ConflictConflict.
+	Old, New, Conflicting []any
+}
+
+var applyConns = struct {
+	sync.RWMutex
+	m map[uintptr]func(ConflictType, Change) ConflictAction
+}{m: map[uintptr]func(ConflictType, Change) ConflictAction{}}
+
+// ApplyChangeset applies changeset, or a patchset, to the main database of
+// c in a savepoint. conflict decides what to do with the changes that
+// conflict with the database; a nil conflict aborts on the first conflict.
+// The changes of tables missing from the database, or with other columns,
+// are skipped.
+//
+// conflict must not use the connection.
+func (c *SQLiteConn) ApplyChangeset(changeset []byte, conflict func(ConflictType, Change) ConflictAction) error {
+	h, err := handleOf(c.conn)
+	if err != nil {
+		return err
+	}
+	in, err := cBuffer(h.tls, changeset)
+	if err != nil {
+		return err
+	}
+	defer sqlite3.Xsqlite3_free(h.tls, in)
+	if conflict == nil {
+		conflict = func(ConflictType, Change) ConflictAction { return ChangesetAbort }
+	}
+	applyConns.Lock()
+	applyConns.m[h.db] = conflict
+	applyConns.Unlock()
+	defer func() {
+		applyConns.Lock()
+		delete(applyConns.m, h.db)
+		applyConns.Unlock()
+	}()
+	if rc := sqlite3.Xsqlite3changeset_apply(h.tls, h.db, int32(len(changeset)), in, 0, cFuncPointer(changesetConflict), h.db); rc != sqlite3.SQLITE_OK {
+		return h.errorOf(rc)
+	}
+	return nil
+}
+
+func changesetConflict(tls *libc.TLS, db uintptr, eConflict int32, pIter uintptr) int32 {
+	applyConns.RLock()
+	fn := applyConns.m[db]
+	applyConns.RUnlock()
+	if fn == nil {
+		return sqlite3.SQLITE_CHANGESET_ABORT
+	}
+
+	p := tls.Alloc(16)
+	defer tls.Free(16)
+	pzTab, pnCol, pOp := p, p+8, p+12
+	if sqlite3.Xsqlite3changeset_op(tls, pIter, pzTab, pnCol, pOp, 0) != sqlite3.SQLITE_OK {
+		return sqlite3.SQLITE_CHANGESET_ABORT
+	}
+	change := Change{Table: libc.GoString(*(*uintptr)(ptr(pzTab))), Op: ChangeOp(*(*int32)(ptr(pOp)))}
+	n := int(*(*int32)(ptr(pnCol)))
+	values := func(get func(tls *libc.TLS, pIter uintptr, iVal int32, ppValue uintptr) int32) []any {
+		pv := tls.Alloc(8)
+		defer tls.Free(8)
+		vals := make([]any, n)
+		for i := range vals {
+			*(*uintptr)(ptr(pv)) = 0
+			if get(tls, pIter, int32(i), pv) != sqlite3.SQLITE_OK {
+				return nil
+			}
+			vals[i] = goValue(tls, *(*uintptr)(ptr(pv)))
+		}
+		return vals
+	}
+	if change.Op != ChangeInsert {
+		change.Old = values(sqlite3.Xsqlite3changeset_old)
+	}
+	if change.Op != ChangeDelete {
+		change.New = values(sqlite3.Xsqlite3changeset_new)
+	}
+	if t := ConflictType(eConflict); t == ConflictData || t == ConflictConflict {
+		change.Conflicting = values(sqlite3.Xsqlite3changeset_conflict)
+	}
+	return int32(fn(ConflictType(eConflict), change))
+}
+
+// goValue returns the Go value of the sqlite3_value v, nil if v is NULL.
+func goValue(tls *libc.TLS, v uintptr) any {
+	if v == 0 {
+		return nil
+	}
+	switch sqlite3.Xsqlite3_value_type(tls, v) {
+	case sqlite3.SQLITE_INTEGER:
+		return sqlite3.Xsqlite3_value_int64(tls, v)
+	case sqlite3.SQLITE_FLOAT:
+		return sqlite3.Xsqlite3_value_double(tls, v)
+	case sqlite3.SQLITE_TEXT:
+		p := sqlite3.Xsqlite3_value_text(tls, v)
+		return string(libc.GoBytes(p, int(sqlite3.Xsqlite3_value_bytes(tls, v))))
+	case sqlite3.SQLITE_BLOB:
+		p := sqlite3.Xsqlite3_value_blob(tls, v)
+		return bytes.Clone(libc.GoBytes(p, int(sqlite3.Xsqlite3_value_bytes(tls, v))))
+	}
+	return nil
+}
+
+// cBuffer copies b into memory allocated with sqlite3_malloc64.
+func cBuffer(tls *libc.TLS, b []byte) (uintptr, error) {
+	p := sqlite3.Xsqlite3_malloc64(tls, uint64(max(len(b), 1)))
+	if p == 0 {
+		return 0, errors.New("sqlite3: out of memory")
+	}
+	copy(libc.GoBytes(p, len(b)), b)
+	return p, nil
+}
+
+// takeBuffer returns a copy of the n bytes at p, allocated by SQLite, and
+// frees p.
+func takeBuffer(tls *libc.TLS, p uintptr, n int) []byte {
+	if p == 0 {
+		return nil
+	}
+	b := bytes.Clone(libc.GoBytes(p, n))
+	sqlite3.Xsqlite3_free(tls, p)
+	return b
+}
@@ -0,0 +1,140 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	open := func(name string) *sql.DB {
+		t.Helper()
+		db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.ExecContext(ctx, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, avatar BLOB); INSERT INTO users VALUES (1, 'foo', NULL), (2, 'bar', NULL)`); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	raw := func(conn *sql.Conn, fn func(*SQLiteConn) error) {
+		t.Helper()
+		if err := conn.Raw(func(dc any) error { return fn(dc.(*SQLiteConn)) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users := func(db *sql.DB) map[int64]string {
+		t.Helper()
+		m := map[int64]string{}
+		err := queryEach(ctx, db, func(rows *sql.Rows) error {
+			var id int64
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				return err
+			}
+			m[id] = name
+			return nil
+		}, `SELECT id, name FROM users`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	src, dst := open("session_src"), open("session_dst")
+	conn, err := src.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var s *Session
+	raw(conn, func(c *SQLiteConn) (err error) {
+		s, err = c.CreateSession("users")
+		return err
+	})
+	if !s.IsEmpty() {
+		t.Fatal("expected an empty session")
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO users VALUES (3, 'baz', x'0102'); UPDATE users SET name = 'qux' WHERE id = 1; DELETE FROM users WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	var changeset, patchset []byte
+	raw(conn, func(c *SQLiteConn) (err error) {
+		if s.IsEmpty() {
+			t.Fatal("expected the session to record the changes")
+		}
+		if changeset, err = s.Changeset(); err != nil {
+			return err
+		}
+		patchset, err = s.Patchset()
+		return err
+	})
+	if len(patchset) == 0 || len(patchset) >= len(changeset) {
+		t.Fatalf("expected a patchset smaller than the changeset, but got %d and %d bytes", len(patchset), len(changeset))
+	}
+	raw(conn, func(c *SQLiteConn) error { return s.Close() })
+
+	// The changeset replays the changes on the other database, and its
+	// inverse undoes them.
+	want := map[int64]string{1: "qux", 3: "baz"}
+	dconn, err := dst.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dconn.Close()
+	raw(dconn, func(c *SQLiteConn) error { return c.ApplyChangeset(changeset, nil) })
+	if got := users(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+	inverse, err := InvertChangeset(changeset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw(dconn, func(c *SQLiteConn) error { return c.ApplyChangeset(inverse, nil) })
+	if got, want := users(dst), map[int64]string{1: "foo", 2: "bar"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+
+	// Conflicts go to the handler; without one, applying aborts.
+	if _, err := dst.ExecContext(ctx, `UPDATE users SET name = 'local' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	err = dconn.Raw(func(dc any) error { return dc.(*SQLiteConn).ApplyChangeset(changeset, nil) })
+	if err == nil {
+		t.Fatal("expected a conflict to abort")
+	}
+	if got, want := users(dst), map[int64]string{1: "local", 2: "bar"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the aborted changeset to be rolled back to %v, but got %v", want, got)
+	}
+	var conflicts []Change
+	raw(dconn, func(c *SQLiteConn) error {
+		return c.ApplyChangeset(changeset, func(ct ConflictType, ch Change) ConflictAction {
+			if ct != ConflictData {
+				t.Errorf("expected ConflictData, but got %v", ct)
+			}
+			conflicts = append(conflicts, ch)
+			return ChangesetReplace
+		})
+	})
+	wantConflict := Change{
+		Table:       "users",
+		Op:          ChangeUpdate,
+		Old:         []any{int64(1), "foo", nil},
+		New:         []any{nil, "qux", nil}, // unchanged columns are nil
+		Conflicting: []any{int64(1), "local", nil},
+	}
+	if len(conflicts) != 1 || !reflect.DeepEqual(conflicts[0], wantConflict) {
+		t.Fatalf("expected %+v, but got %+v", wantConflict, conflicts)
+	}
+	if got := users(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+
+	if _, err := InvertChangeset(patchset); err == nil {
+		t.Fatal("expected inverting a patchset to fail")
+	}
+}
@@ -0,0 +1,194 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// checkSlowQuery logs the statement st if it took longer than _slow_query_ms.
+//
+// The record is a warning with the query, its duration, the types of its
+// arguments (their values are redacted), the query plan and the caller. The
+// plan is captured with EXPLAIN QUERY PLAN on a side connection to the same
+// database, so that a transaction or result set open on c is not disturbed;
+// it is missing for databases the side connection cannot see, like private
+// in-memory databases and temporary tables.
+func (c *SQLiteConn) checkSlowQuery(ctx context.Context, st stmtTrace, d time.Duration) {
+	if c.slowQuery == 0 || d < c.slowQuery || st.op == OperationPrepare {
+		return
+	}
+	logger := c.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []slog.Attr{
+		slog.String("query", st.query),
+		slog.String("operation", string(st.op)),
+		slog.Duration("duration", d),
+		slog.Any("args", redactArgs(st.args)),
+	}
+	if plan, err := c.explain(ctx, st.query, st.args); err != nil {
+		attrs = append(attrs, slog.String("plan_error", err.Error()))
+	} else if len(plan) > 0 {
+		attrs = append(attrs, slog.String("plan", formatPlan(plan)), slog.Bool("full_scan", fullScan(plan)))
+	}
+	if caller := callerOutside(); caller != "" {
+		attrs = append(attrs, slog.String("caller", caller))
+	}
+	logger.LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+}
+
+// planStep is a row of EXPLAIN QUERY PLAN.
+type planStep struct {
+	id, parent int64
+	detail     string
+}
+
+// explain returns the query plan of query with args, run on the side
+// connection of c.
+func (c *SQLiteConn) explain(ctx context.Context, query string, args []driver.NamedValue) ([]planStep, error) {
+	if c.explainConn == nil {
+		// Opened like any connection of the DSN, e.g. with its
+		// _busy_timeout, so that it waits for the locks of the others.
+		conn, err := (&SQLiteDriver{}).Open(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		sc, ok := conn.(*SQLiteConn)
+		if !ok {
+			_ = conn.Close()
+			return nil, fmt.Errorf("sqlite3: unexpected connection type %T", conn)
+		}
+		if _, err := sc.conn.ExecContext(ctx, "PRAGMA query_only = 1", nil); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+		c.explainConn = sc
+	}
+
+	rows, err := c.explainConn.conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plan []planStep
+	values := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(values); err == io.EOF {
+			return plan, nil
+		} else if err != nil {
+			return nil, err
+		}
+		step := planStep{}
+		step.id, _ = values[0].(int64)
+		step.parent, _ = values[1].(int64)
+		switch v := values[3].(type) {
+		case string:
+			step.detail = v
+		case []byte:
+			step.detail = string(v)
+		}
+		plan = append(plan, step)
+	}
+}
+
+// formatPlan lays plan out as a tree, the way the sqlite3 shell does:
+//
+//	QUERY PLAN
+//	|--SCAN users
+//	`--USE TEMP B-TREE FOR ORDER BY
+func formatPlan(plan []planStep) string {
+	var b strings.Builder
+	b.WriteString("QUERY PLAN")
+	var walk func(parent int64, prefix string)
+	walk = func(parent int64, prefix string) {
+		var children []planStep
+		for _, step := range plan {
+			if step.parent == parent {
+				children = append(children, step)
+			}
+		}
+		for i, step := range children {
+			branch, indent := "|--", "|  "
+			if i == len(children)-1 {
+				branch, indent = "`--", "   "
+			}
+			b.WriteString("\n" + prefix + branch + step.detail)
+			walk(step.id, prefix+indent)
+		}
+	}
+	walk(0, "")
+	return b.String()
+}
+
+// fullScan reports whether plan scans a table or an index from start to end.
+func fullScan(plan []planStep) bool {
+	for _, step := range plan {
+		if strings.HasPrefix(step.detail, "SCAN ") {
+			return true
+		}
+	}
+	return false
+}
+
+// redactArgs describes args by their types only, e.g. "$1: string".
+func redactArgs(args []driver.NamedValue) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		name := fmt.Sprintf("$%d", arg.Ordinal)
+		if arg.Name != "" {
+			name = ":" + arg.Name
+		}
+		typ := "NULL"
+		if arg.Value != nil {
+			typ = fmt.Sprintf("%T", arg.Value)
+		}
+		redacted[i] = name + ": " + typ
+	}
+	return redacted
+}
+
+// callerOutside returns the file:line of the first caller outside of this
+// module, database/sql and ent, or "" if there is none.
+func callerOutside() string {
+	pc := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	for {
+		f, more := frames.Next()
+		if !internalFrame(f) {
+			return fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// modulePath is the path of this module, whose packages, like otel and
+// entdriver, are not callers.
+const modulePath = "github.com/sqlite3ent/sqlite3"
+
+func internalFrame(f runtime.Frame) bool {
+	switch {
+	case strings.HasPrefix(f.Function, modulePath+"/example"):
+		return false
+	case strings.HasPrefix(f.Function, modulePath+"."),
+		strings.HasPrefix(f.Function, modulePath+"/"):
+		// Tests of the module are callers like any other.
+		return !strings.HasSuffix(f.File, "_test.go")
+	case strings.HasPrefix(f.Function, "database/sql."),
+		strings.HasPrefix(f.Function, "entgo.io/ent/"),
+		strings.HasPrefix(f.Function, "runtime."),
+		f.Function == "":
+		return true
+	}
+	return false
+}
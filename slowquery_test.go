@@ -0,0 +1,135 @@
+package sqlite3
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_slow_query_ms=1")
+	c.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200000)
+		INSERT INTO test (name) SELECT 'name-' || i FROM n`); err != nil {
+		t.Fatal(err)
+	}
+	const query = `SELECT id FROM test WHERE name = ?`
+	rows, err := db.QueryContext(ctx, query, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r["query"] == query {
+			record = r
+		}
+	}
+	if record == nil {
+		t.Fatalf("expected a slow query record for %q in:\n%s", query, buf.String())
+	}
+	if record["msg"] != "slow query" || record["level"] != "WARN" {
+		t.Fatalf("unexpected record: %v", record)
+	}
+	if plan, _ := record["plan"].(string); !strings.Contains(plan, "SCAN test") || record["full_scan"] != true {
+		t.Fatalf("expected a full scan plan, but got %v", record)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatal("expected the arguments to be redacted")
+	}
+	if args, _ := record["args"].([]any); len(args) != 1 || args[0] != "$1: string" {
+		t.Fatalf("unexpected args: %v", record["args"])
+	}
+	if caller, _ := record["caller"].(string); !strings.Contains(caller, "slowquery_test.go") {
+		t.Fatalf("expected the caller in the test, but got %q", caller)
+	}
+}
+
+func TestSlowQueryExplainBusy(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_slow_query_ms=60000&_busy_timeout=5000&_journal_mode=DELETE"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	locker, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locker.Close()
+
+	// The side connection waits for the exclusive lock of another one
+	// instead of failing with SQLITE_BUSY.
+	if _, err := locker.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = locker.ExecContext(ctx, `COMMIT`)
+	}()
+	err = conn.Raw(func(dc any) error {
+		plan, err := dc.(*SQLiteConn).explain(ctx, `SELECT id FROM test`, nil)
+		if err == nil && len(plan) == 0 {
+			t.Fatal("expected a plan")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected the plan once the lock is released, but got %v", err)
+	}
+}
+
+func TestInternalFrame(t *testing.T) {
+	for _, tt := range []struct {
+		function, file string
+		internal       bool
+	}{
+		{"github.com/sqlite3ent/sqlite3.(*SQLiteConn).QueryContext", "/src/sqlite3/conn.go", true},
+		{"github.com/sqlite3ent/sqlite3/otel.(*tracer).OnQuery", "/src/sqlite3/otel/otel.go", true},
+		{"github.com/sqlite3ent/sqlite3/entdriver.(*Driver).Query", "/src/sqlite3/entdriver/driver.go", true},
+		{"github.com/sqlite3ent/sqlite3/otel.TestTracer", "/src/sqlite3/otel/otel_test.go", false},
+		{"github.com/sqlite3ent/sqlite3/example.main", "/src/sqlite3/example/main.go", false},
+		{"github.com/sqlite3ent/sqlite3x.main", "/src/sqlite3x/main.go", false},
+		{"database/sql.(*DB).QueryContext", "/go/src/database/sql/sql.go", true},
+		{"main.main", "/src/app/main.go", false},
+	} {
+		if got := internalFrame(runtime.Frame{Function: tt.function, File: tt.file}); got != tt.internal {
+			t.Fatalf("expected internalFrame of %s to be %v, but got %v", tt.function, tt.internal, got)
+		}
+	}
+}
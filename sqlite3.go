@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"modernc.org/sqlite"
 )
@@ -58,6 +59,13 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 	//vfsName := ""
 	var cacheSize *int64
 
+	// Driver options
+	var slowQuery time.Duration
+	limits := map[Limit]int{}
+	nestedTx := false
+	stmtCache := 0
+	persistWAL := -1
+
 	pos := strings.IndexRune(dsn, '?')
 	if pos >= 1 {
 		params, err := url.ParseQuery(dsn[pos+1:])
@@ -396,6 +404,79 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 			cacheSize = &iv
 		}
 
+		// Limits (_limit_sql_length, _limit_expr_depth, ...)
+		//
+		// https://www.sqlite.org/c3ref/c_limit_attached.html
+		//
+		for name, id := range limitParams {
+			if val := params.Get(name); val != "" {
+				iv, err := strconv.ParseInt(val, 10, 32)
+				if err != nil || iv < 0 {
+					return nil, fmt.Errorf("invalid %s: %v, expecting a non-negative number", name, val)
+				}
+				limits[id] = int(iv)
+			}
+		}
+
+		// Slow query log (_slow_query_ms)
+		//
+		// Statements running longer are logged with their query plan.
+		//
+		if val := params.Get("_slow_query_ms"); val != "" {
+			iv, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || iv < 0 {
+				return nil, fmt.Errorf("invalid _slow_query_ms: %v, expecting a non-negative number of milliseconds", val)
+			}
+			slowQuery = time.Duration(iv) * time.Millisecond
+		}
+
+		// Nested transactions (_nested_tx)
+		//
+		// With SAVEPOINT, a BeginTx on a connection inside a transaction
+		// opens a savepoint instead of failing.
+		//
+		if val := params.Get("_nested_tx"); val != "" {
+			switch strings.ToUpper(val) {
+			case "SAVEPOINT":
+				nestedTx = true
+			case "NONE", "OFF":
+				nestedTx = false
+			default:
+				return nil, fmt.Errorf("invalid _nested_tx: %v, expecting value of 'SAVEPOINT NONE'", val)
+			}
+		}
+
+		// Statement cache (_stmt_cache)
+		//
+		// Number of prepared statements kept per connection, keyed by
+		// their SQL. 0 disables the cache.
+		//
+		if val := params.Get("_stmt_cache"); val != "" {
+			iv, err := strconv.ParseInt(val, 10, 32)
+			if err != nil || iv < 0 {
+				return nil, fmt.Errorf("invalid _stmt_cache: %v, expecting a non-negative number of statements", val)
+			}
+			stmtCache = int(iv)
+		}
+
+		// Persistent WAL (_persist_wal)
+		//
+		// With persistent WAL, the last connection closing the database
+		// truncates the -wal file instead of deleting it.
+		//
+		// https://sqlite.org/c3ref/c_fcntl_begin_atomic_write.html#sqlitefcntlpersistwal
+		//
+		if val := params.Get("_persist_wal"); val != "" {
+			switch strings.ToLower(val) {
+			case "0", "no", "false", "off":
+				persistWAL = 0
+			case "1", "yes", "true", "on":
+				persistWAL = 1
+			default:
+				return nil, fmt.Errorf("invalid _persist_wal: %v, expecting boolean value of '0 1 false true no yes off on'", val)
+			}
+		}
+
 		//if val := params.Get("vfs"); val != "" {
 		//	vfsName = val
 		//}
@@ -554,6 +635,46 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 	//		return nil, err
 	//	}
 	//}
-	runtime.SetFinalizer(conn, sqliteConn.Close)
-	return c, nil
+	sc := &SQLiteConn{conn: conn, dsn: dsn, busyTimeout: busyTimeout, slowQuery: slowQuery, nestedTx: nestedTx}
+	if stmtCache > 0 {
+		sc.stmts = newStmtCache(stmtCache)
+	}
+
+	// Limits
+	for id, value := range limits {
+		if _, err := sc.SetLimit(id, value); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	// Persistent WAL
+	if persistWAL > -1 {
+		if _, err := sc.FileControlPersistWAL("main", persistWAL); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	// Busy handler
+	// Installed after PRAGMA busy_timeout, which it replaces, so that busy
+	// waits end with the context of the statement.
+	// The progress handler and the authorizer of a Connector are installed
+	// once Open returned, so they do not see the PRAGMAs above.
+	if err := sc.setBusyHandler(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// Actor of the audit log, see EnableAudit
+	if err := sc.registerAuditActor(); err != nil {
+		sc.clearBusyHandler()
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// A connection leaked without Close is closed once collected. The
+	// cleanup is set on sc rather than conn, which sc keeps reachable.
+	sc.cleanups = append(sc.cleanups, runtime.AddCleanup(sc, closeLeaked, leakedConn{conn: conn, db: sc.busyDB}))
+	return sc, nil
 }
@@ -3,8 +3,14 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDriver(t *testing.T) {
@@ -124,6 +130,21 @@ func TestSQLiteDriver_Open(t *testing.T) {
 			dsn:     "file:" + tmpfile.Name() + "?cache=shared&_journal=WAL&_fk=1",
 			wantErr: false,
 		},
+		{
+			name:    "invalid _slow_query_ms",
+			dsn:     "file:" + tmpfile.Name() + "?_slow_query_ms=fast",
+			wantErr: true,
+		},
+		{
+			name:    "invalid _limit_sql_length",
+			dsn:     "file:" + tmpfile.Name() + "?_limit_sql_length=-1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid _stmt_cache",
+			dsn:     "file:" + tmpfile.Name() + "?_stmt_cache=all",
+			wantErr: true,
+		},
 		{
 			name:    "invalid dsn",
 			dsn:     "file://invalid?mode=invalid&cache=invalid",
@@ -179,3 +200,186 @@ func TestSQLiteDriver_Open(t *testing.T) {
 		})
 	}
 }
+
+func TestLeakedConnClosed(t *testing.T) {
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db"))
+	c.Metrics = &Metrics{}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := handleOf(conn.(*SQLiteConn).conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = nil
+
+	// The connection is dropped without Close: once collected, it is
+	// closed and forgotten by the driver.
+	registered := func() bool {
+		busyConns.RLock()
+		defer busyConns.RUnlock()
+		_, ok := busyConns.m[h.db]
+		return ok
+	}
+	for i := 0; i < 100 && (registered() || c.openBefore(1) > 0); i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if registered() {
+		t.Fatal("expected the busy handler of the leaked connection to be forgotten")
+	}
+	if n := c.openBefore(1); n != 0 {
+		t.Fatalf("expected no open connection, but got %d", n)
+	}
+	if n := c.Metrics.Stats().OpenConnections; n != 0 {
+		t.Fatalf("expected no open connection in the metrics, but got %d", n)
+	}
+}
+
+// endless yields the row 1 at once, then counts forever.
+const endless = `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT i FROM n WHERE i = 1 OR i < 0`
+
+// connectCounter is a ConnectTracer counting the connections opened.
+type connectCounter struct {
+	n atomic.Int32
+}
+
+func (c *connectCounter) OnQueryStart(ctx context.Context, query string, args []driver.NamedValue) context.Context {
+	return ctx
+}
+
+func (c *connectCounter) OnQueryEnd(ctx context.Context, duration time.Duration, rowsAffected int64, err error) {
+}
+
+func (c *connectCounter) OnConnect(ctx context.Context, conn *SQLiteConn, duration time.Duration, err error) {
+	c.n.Add(1)
+}
+
+// cancelDB is a database with a single connection, so that the statements
+// after a cancellation run on the connection that was interrupted, unless
+// database/sql discarded it.
+type cancelDB struct {
+	*sql.DB
+	connects *connectCounter
+}
+
+func openCancelDB(t *testing.T, dsn string) cancelDB {
+	t.Helper()
+	c := NewConnector(dsn)
+	connects := &connectCounter{}
+	c.Tracer = connects
+	db := sql.OpenDB(c)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	return cancelDB{DB: db, connects: connects}
+}
+
+// checkClean fails t unless the connection of db was reused after the
+// cancellation and runs statements outside of any transaction.
+func checkClean(t *testing.T, db cancelDB) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("expected a clean connection, but got %v", err)
+	}
+	var one int
+	if err := tx.QueryRow(`SELECT 1`).Scan(&one); err != nil {
+		t.Fatalf("expected a clean connection, but got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.connects.n.Load(); n != 1 {
+		t.Fatalf("expected the interrupted connection to be reused, but %d connections were opened", n)
+	}
+}
+
+func TestCancelQuery(t *testing.T) {
+	db := openCancelDB(t, "file:"+filepath.Join(t.TempDir(), "test.db"))
+
+	// Cancelled while the first row is computed.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+endless+`)`).Scan(&count)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+	checkClean(t, db)
+
+	// Cancelled while iterating over the rows.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, endless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	for rows.Next() {
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected the iteration to stop soon after the cancellation, but it took %v", d)
+	}
+	if err := rows.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+	rows.Close()
+	checkClean(t, db)
+}
+
+func TestCancelExec(t *testing.T) {
+	db := openCancelDB(t, "file:"+filepath.Join(t.TempDir(), "test.db"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := db.ExecContext(ctx, `CREATE TABLE test AS `+endless)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+	checkClean(t, db)
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'test'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected the cancelled statement to be rolled back")
+	}
+}
+
+func TestCancelBeginBusy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	locker := openCancelDB(t, file)
+	if _, err := locker.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := locker.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO test DEFAULT VALUES`); err != nil {
+		t.Fatal(err)
+	}
+
+	db := openCancelDB(t, file+"?_busy_timeout=60000&_txlock=immediate")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := db.BeginTx(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, but got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected BEGIN to stop waiting soon after the cancellation, but it took %v", d)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkClean(t, db)
+}
@@ -0,0 +1,506 @@
+// Package sqlite3test provides helpers for testing code built on top of the sqlite3 driver.
+package sqlite3test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// FaultyVFSName is the name the default fault-injection VFS is registered under.
+// Select it with the vfs DSN parameter, e.g. "file:test.db?vfs=faulty".
+const FaultyVFSName = "faulty"
+
+// Faulty is the fault-injection VFS registered as FaultyVFSName.
+//
+// It is shared by every database opened with vfs=faulty, so tests that run in
+// parallel should register a VFS of their own with NewVFS instead.
+var Faulty *VFS
+
+func init() {
+	var err error
+	if Faulty, err = NewVFS(FaultyVFSName); err != nil {
+		panic(err)
+	}
+}
+
+// Op identifies the VFS method a Fault applies to.
+type Op int
+
+const (
+	OpOpen     Op = iota + 1 // xOpen
+	OpDelete                 // xDelete
+	OpRead                   // xRead
+	OpWrite                  // xWrite
+	OpTruncate               // xTruncate
+	OpSync                   // xSync
+	OpFileSize               // xFileSize
+	OpLock                   // xLock
+)
+
+var opNames = map[Op]string{
+	OpOpen:     "xOpen",
+	OpDelete:   "xDelete",
+	OpRead:     "xRead",
+	OpWrite:    "xWrite",
+	OpTruncate: "xTruncate",
+	OpSync:     "xSync",
+	OpFileSize: "xFileSize",
+	OpLock:     "xLock",
+}
+
+func (o Op) String() string {
+	if s, ok := opNames[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// Fault describes a failure injected into a VFS method.
+//
+// For example, failing the 3rd write to the WAL file with SQLITE_FULL is
+//
+//	Fault{Op: OpWrite, File: "-wal", Nth: 3, Code: sqlite3.SQLITE_FULL}
+//
+// and delaying every sync by 200ms is
+//
+//	Fault{Op: OpSync, Delay: 200 * time.Millisecond}
+//
+// where the result codes come from modernc.org/sqlite/lib.
+type Fault struct {
+	// Op is the VFS method the fault applies to.
+	Op Op
+
+	// File restricts the fault to files whose name ends with File, such as
+	// "-wal" or "-journal". Empty matches every file.
+	File string
+
+	// Nth is the 1-based number of the matching call the fault fires on,
+	// counted from the time the fault was injected. The fault is discarded
+	// once it fired. Zero fires on every matching call.
+	Nth int
+
+	// Code is the SQLite result code the call fails with, e.g. SQLITE_FULL
+	// or SQLITE_IOERR_WRITE. Zero lets the call proceed after Delay.
+	Code int
+
+	// Short is the number of bytes an OpWrite stores before failing with
+	// Code, simulating a short write. It is ignored for other operations.
+	Short int
+
+	// Delay is how long the call sleeps before it fails or proceeds.
+	Delay time.Duration
+}
+
+type fault struct {
+	Fault
+	seen int
+}
+
+// VFS is a SQLite VFS that forwards to the default VFS of the process and
+// fails or delays calls according to the faults injected into it.
+//
+// A VFS stays registered for the lifetime of the process.
+type VFS struct {
+	name  string
+	pVfs  uintptr // *sqlite3_vfs registered under name
+	pReal uintptr // *sqlite3_vfs every call is forwarded to
+
+	mu     sync.Mutex
+	faults []*fault
+}
+
+// NewVFS registers a fault-injection VFS under name. Databases opened with
+// vfs=name go through it.
+func NewVFS(name string) (*VFS, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	pReal := sqlite3.Xsqlite3_vfs_find(tls, 0)
+	if pReal == 0 {
+		return nil, fmt.Errorf("sqlite3test: no default vfs")
+	}
+	zName, err := libc.CString(name)
+	if err != nil {
+		return nil, err
+	}
+	pVfs := sqlite3.Xsqlite3_malloc64(tls, uint64(unsafe.Sizeof(sqlite3.Tsqlite3_vfs{})))
+	if pVfs == 0 {
+		libc.Xfree(tls, zName)
+		return nil, fmt.Errorf("sqlite3test: out of memory")
+	}
+
+	v := &VFS{name: name, pVfs: pVfs, pReal: pReal}
+	vfsMu.Lock()
+	vfsToken++
+	token := vfsToken
+	vfss[token] = v
+	vfsMu.Unlock()
+
+	// The methods that take no file are forwarded as is: none of the
+	// default VFS implementations of them look at their pVfs argument.
+	real := (*sqlite3.Tsqlite3_vfs)(ptr(pReal))
+	*(*sqlite3.Tsqlite3_vfs)(ptr(pVfs)) = sqlite3.Tsqlite3_vfs{
+		FiVersion:          2,
+		FszOsFile:          real.FszOsFile + fileHeaderSize,
+		FmxPathname:        real.FmxPathname,
+		FzName:             zName,
+		FpAppData:          token,
+		FxOpen:             cFuncPointer(vfsOpen),
+		FxDelete:           cFuncPointer(vfsDelete),
+		FxAccess:           cFuncPointer(vfsAccess),
+		FxFullPathname:     cFuncPointer(vfsFullPathname),
+		FxDlOpen:           real.FxDlOpen,
+		FxDlError:          real.FxDlError,
+		FxDlSym:            real.FxDlSym,
+		FxDlClose:          real.FxDlClose,
+		FxRandomness:       real.FxRandomness,
+		FxSleep:            real.FxSleep,
+		FxCurrentTime:      real.FxCurrentTime,
+		FxGetLastError:     real.FxGetLastError,
+		FxCurrentTimeInt64: real.FxCurrentTimeInt64,
+	}
+	if real.FiVersion < 2 {
+		(*sqlite3.Tsqlite3_vfs)(ptr(pVfs)).FiVersion = 1
+	}
+	if rc := sqlite3.Xsqlite3_vfs_register(tls, pVfs, 0); rc != sqlite3.SQLITE_OK {
+		vfsMu.Lock()
+		delete(vfss, token)
+		vfsMu.Unlock()
+		sqlite3.Xsqlite3_free(tls, pVfs)
+		libc.Xfree(tls, zName)
+		return nil, fmt.Errorf("sqlite3test: registering vfs %q: result code %d", name, rc)
+	}
+	return v, nil
+}
+
+// Name returns the name the VFS is registered under.
+func (v *VFS) Name() string {
+	return v.name
+}
+
+// Inject adds f to the faults of the VFS.
+func (v *VFS) Inject(f Fault) {
+	v.mu.Lock()
+	v.faults = append(v.faults, &fault{Fault: f})
+	v.mu.Unlock()
+}
+
+// Reset discards every fault injected into the VFS.
+func (v *VFS) Reset() {
+	v.mu.Lock()
+	v.faults = nil
+	v.mu.Unlock()
+}
+
+// inject applies the faults matching a call of op on the named file. It
+// returns the result code the call must fail with, or SQLITE_OK if the call
+// proceeds, and for failed writes the number of bytes to store first.
+func (v *VFS) inject(op Op, name string) (rc int32, short int) {
+	var delay time.Duration
+	rc = sqlite3.SQLITE_OK
+	v.mu.Lock()
+	faults := v.faults[:0]
+	for _, f := range v.faults {
+		if f.Op != op || !strings.HasSuffix(name, f.File) {
+			faults = append(faults, f)
+			continue
+		}
+		f.seen++
+		if f.Nth != 0 && f.seen != f.Nth {
+			faults = append(faults, f)
+			continue
+		}
+		delay += f.Delay
+		if f.Code != 0 && rc == sqlite3.SQLITE_OK {
+			rc, short = int32(f.Code), f.Short
+		}
+		if f.Nth == 0 {
+			faults = append(faults, f)
+		}
+	}
+	v.faults = faults
+	v.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return rc, short
+}
+
+var (
+	vfsMu    sync.Mutex
+	vfsToken uintptr
+	vfss     = map[uintptr]*VFS{}
+
+	// files maps an open sqlite3_file of a fault-injection VFS to its state.
+	files sync.Map // map[uintptr]*file
+)
+
+type file struct {
+	vfs  *VFS
+	name string
+}
+
+// fileHeaderSize is the room reserved in front of the forwarded sqlite3_file
+// for the pMethods pointer of the fault-injection file, padded to 8 bytes.
+const fileHeaderSize = 8
+
+func lookupVFS(pVfs uintptr) *VFS {
+	vfsMu.Lock()
+	defer vfsMu.Unlock()
+	return vfss[(*sqlite3.Tsqlite3_vfs)(ptr(pVfs)).FpAppData]
+}
+
+func lookupFile(pFile uintptr) *file {
+	f, _ := files.Load(pFile)
+	return f.(*file)
+}
+
+// realFile returns the forwarded sqlite3_file embedded in pFile and its methods.
+func realFile(pFile uintptr) (uintptr, *sqlite3.Tsqlite3_io_methods) {
+	p := pFile + fileHeaderSize
+	return p, (*sqlite3.Tsqlite3_io_methods)(ptr((*sqlite3.Tsqlite3_file)(ptr(p)).FpMethods))
+}
+
+func vfsOpen(tls *libc.TLS, pVfs, zName, pFile uintptr, flags int32, pOutFlags uintptr) int32 {
+	v := lookupVFS(pVfs)
+	var name string
+	if zName != 0 {
+		name = libc.GoString(zName)
+	}
+	(*sqlite3.Tsqlite3_file)(ptr(pFile)).FpMethods = 0
+	if rc, _ := v.inject(OpOpen, name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+
+	real := (*sqlite3.Tsqlite3_vfs)(ptr(v.pReal))
+	p := pFile + fileHeaderSize
+	rc := cFunc[func(*libc.TLS, uintptr, uintptr, uintptr, int32, uintptr) int32](real.FxOpen)(tls, v.pReal, zName, p, flags, pOutFlags)
+	if rc != sqlite3.SQLITE_OK {
+		// A method table left behind by a failed open still has to be closed.
+		if pm := (*sqlite3.Tsqlite3_file)(ptr(p)).FpMethods; pm != 0 {
+			cFunc[func(*libc.TLS, uintptr) int32]((*sqlite3.Tsqlite3_io_methods)(ptr(pm)).FxClose)(tls, p)
+		}
+		return rc
+	}
+	files.Store(pFile, &file{vfs: v, name: name})
+	(*sqlite3.Tsqlite3_file)(ptr(pFile)).FpMethods = pIoMethods
+	return rc
+}
+
+func vfsDelete(tls *libc.TLS, pVfs, zName uintptr, syncDir int32) int32 {
+	v := lookupVFS(pVfs)
+	if rc, _ := v.inject(OpDelete, libc.GoString(zName)); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	real := (*sqlite3.Tsqlite3_vfs)(ptr(v.pReal))
+	return cFunc[func(*libc.TLS, uintptr, uintptr, int32) int32](real.FxDelete)(tls, v.pReal, zName, syncDir)
+}
+
+func vfsAccess(tls *libc.TLS, pVfs, zName uintptr, flags int32, pResOut uintptr) int32 {
+	v := lookupVFS(pVfs)
+	real := (*sqlite3.Tsqlite3_vfs)(ptr(v.pReal))
+	return cFunc[func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32](real.FxAccess)(tls, v.pReal, zName, flags, pResOut)
+}
+
+func vfsFullPathname(tls *libc.TLS, pVfs, zName uintptr, nOut int32, zOut uintptr) int32 {
+	v := lookupVFS(pVfs)
+	real := (*sqlite3.Tsqlite3_vfs)(ptr(v.pReal))
+	return cFunc[func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32](real.FxFullPathname)(tls, v.pReal, zName, nOut, zOut)
+}
+
+// pIoMethods is the sqlite3_io_methods shared by every file of a
+// fault-injection VFS.
+var pIoMethods = func() uintptr {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	p := sqlite3.Xsqlite3_malloc64(tls, uint64(unsafe.Sizeof(sqlite3.Tsqlite3_io_methods{})))
+	if p == 0 {
+		panic("sqlite3test: out of memory")
+	}
+	*(*sqlite3.Tsqlite3_io_methods)(ptr(p)) = sqlite3.Tsqlite3_io_methods{
+		FiVersion:               3,
+		FxClose:                 cFuncPointer(fileClose),
+		FxRead:                  cFuncPointer(fileRead),
+		FxWrite:                 cFuncPointer(fileWrite),
+		FxTruncate:              cFuncPointer(fileTruncate),
+		FxSync:                  cFuncPointer(fileSync),
+		FxFileSize:              cFuncPointer(fileFileSize),
+		FxLock:                  cFuncPointer(fileLock),
+		FxUnlock:                cFuncPointer(fileUnlock),
+		FxCheckReservedLock:     cFuncPointer(fileCheckReservedLock),
+		FxFileControl:           cFuncPointer(fileFileControl),
+		FxSectorSize:            cFuncPointer(fileSectorSize),
+		FxDeviceCharacteristics: cFuncPointer(fileDeviceCharacteristics),
+		FxShmMap:                cFuncPointer(fileShmMap),
+		FxShmLock:               cFuncPointer(fileShmLock),
+		FxShmBarrier:            cFuncPointer(fileShmBarrier),
+		FxShmUnmap:              cFuncPointer(fileShmUnmap),
+		FxFetch:                 cFuncPointer(fileFetch),
+		FxUnfetch:               cFuncPointer(fileUnfetch),
+	}
+	return p
+}()
+
+func fileClose(tls *libc.TLS, pFile uintptr) int32 {
+	files.Delete(pFile)
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr) int32](m.FxClose)(tls, p)
+}
+
+func fileRead(tls *libc.TLS, pFile, zBuf uintptr, iAmt int32, iOfst int64) int32 {
+	f := lookupFile(pFile)
+	if rc, _ := f.vfs.inject(OpRead, f.name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, uintptr, int32, int64) int32](m.FxRead)(tls, p, zBuf, iAmt, iOfst)
+}
+
+func fileWrite(tls *libc.TLS, pFile, zBuf uintptr, iAmt int32, iOfst int64) int32 {
+	f := lookupFile(pFile)
+	p, m := realFile(pFile)
+	write := cFunc[func(*libc.TLS, uintptr, uintptr, int32, int64) int32](m.FxWrite)
+	if rc, short := f.vfs.inject(OpWrite, f.name); rc != sqlite3.SQLITE_OK {
+		if short > 0 {
+			write(tls, p, zBuf, min(int32(short), iAmt), iOfst)
+		}
+		return rc
+	}
+	return write(tls, p, zBuf, iAmt, iOfst)
+}
+
+func fileTruncate(tls *libc.TLS, pFile uintptr, size int64) int32 {
+	f := lookupFile(pFile)
+	if rc, _ := f.vfs.inject(OpTruncate, f.name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, int64) int32](m.FxTruncate)(tls, p, size)
+}
+
+func fileSync(tls *libc.TLS, pFile uintptr, flags int32) int32 {
+	f := lookupFile(pFile)
+	if rc, _ := f.vfs.inject(OpSync, f.name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, int32) int32](m.FxSync)(tls, p, flags)
+}
+
+func fileFileSize(tls *libc.TLS, pFile, pSize uintptr) int32 {
+	f := lookupFile(pFile)
+	if rc, _ := f.vfs.inject(OpFileSize, f.name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, uintptr) int32](m.FxFileSize)(tls, p, pSize)
+}
+
+func fileLock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	f := lookupFile(pFile)
+	if rc, _ := f.vfs.inject(OpLock, f.name); rc != sqlite3.SQLITE_OK {
+		return rc
+	}
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, int32) int32](m.FxLock)(tls, p, lock)
+}
+
+func fileUnlock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, int32) int32](m.FxUnlock)(tls, p, lock)
+}
+
+func fileCheckReservedLock(tls *libc.TLS, pFile, pResOut uintptr) int32 {
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, uintptr) int32](m.FxCheckReservedLock)(tls, p, pResOut)
+}
+
+func fileFileControl(tls *libc.TLS, pFile uintptr, op int32, pArg uintptr) int32 {
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr, int32, uintptr) int32](m.FxFileControl)(tls, p, op, pArg)
+}
+
+func fileSectorSize(tls *libc.TLS, pFile uintptr) int32 {
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr) int32](m.FxSectorSize)(tls, p)
+}
+
+func fileDeviceCharacteristics(tls *libc.TLS, pFile uintptr) int32 {
+	p, m := realFile(pFile)
+	return cFunc[func(*libc.TLS, uintptr) int32](m.FxDeviceCharacteristics)(tls, p)
+}
+
+func fileShmMap(tls *libc.TLS, pFile uintptr, iPg, pgsz, bExtend int32, pp uintptr) int32 {
+	p, m := realFile(pFile)
+	if m.FiVersion < 2 || m.FxShmMap == 0 {
+		return sqlite3.SQLITE_IOERR_SHMMAP
+	}
+	return cFunc[func(*libc.TLS, uintptr, int32, int32, int32, uintptr) int32](m.FxShmMap)(tls, p, iPg, pgsz, bExtend, pp)
+}
+
+func fileShmLock(tls *libc.TLS, pFile uintptr, offset, n, flags int32) int32 {
+	p, m := realFile(pFile)
+	if m.FiVersion < 2 || m.FxShmLock == 0 {
+		return sqlite3.SQLITE_IOERR_SHMLOCK
+	}
+	return cFunc[func(*libc.TLS, uintptr, int32, int32, int32) int32](m.FxShmLock)(tls, p, offset, n, flags)
+}
+
+func fileShmBarrier(tls *libc.TLS, pFile uintptr) {
+	p, m := realFile(pFile)
+	if m.FiVersion < 2 || m.FxShmBarrier == 0 {
+		return
+	}
+	cFunc[func(*libc.TLS, uintptr)](m.FxShmBarrier)(tls, p)
+}
+
+func fileShmUnmap(tls *libc.TLS, pFile uintptr, deleteFlag int32) int32 {
+	p, m := realFile(pFile)
+	if m.FiVersion < 2 || m.FxShmUnmap == 0 {
+		return sqlite3.SQLITE_OK
+	}
+	return cFunc[func(*libc.TLS, uintptr, int32) int32](m.FxShmUnmap)(tls, p, deleteFlag)
+}
+
+func fileFetch(tls *libc.TLS, pFile uintptr, iOfst int64, iAmt int32, pp uintptr) int32 {
+	p, m := realFile(pFile)
+	if m.FiVersion < 3 || m.FxFetch == 0 {
+		*(*uintptr)(ptr(pp)) = 0
+		return sqlite3.SQLITE_OK
+	}
+	return cFunc[func(*libc.TLS, uintptr, int64, int32, uintptr) int32](m.FxFetch)(tls, p, iOfst, iAmt, pp)
+}
+
+func fileUnfetch(tls *libc.TLS, pFile uintptr, iOfst int64, pPage uintptr) int32 {
+	p, m := realFile(pFile)
+	if m.FiVersion < 3 || m.FxUnfetch == 0 {
+		return sqlite3.SQLITE_OK
+	}
+	return cFunc[func(*libc.TLS, uintptr, int64, uintptr) int32](m.FxUnfetch)(tls, p, iOfst, pPage)
+}
+
+// ptr converts an address in C memory into an unsafe.Pointer.
+func ptr(p uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&p))
+}
+
+// cFuncPointer returns the C function pointer SQLite calls f through.
+//
+// This assumes the memory representation of func values described in
+// https://golang.org/s/go11func, the same way modernc.org/sqlite does.
+func cFuncPointer[T any](f T) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&struct{ f T }{f}))
+}
+
+// cFunc returns the C function pointer fp as a callable Go func of type T.
+func cFunc[T any](fp uintptr) T {
+	return *(*T)(unsafe.Pointer(&struct{ uintptr }{fp}))
+}
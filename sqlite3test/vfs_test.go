@@ -0,0 +1,79 @@
+package sqlite3test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	_ "github.com/sqlite3ent/sqlite3"
+)
+
+func TestFaultyVFS(t *testing.T) {
+	v, err := NewVFS("faulty-" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?vfs=" + v.Name() + "&_journal=WAL&_sync=FULL"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the 2nd write to the WAL file with SQLITE_FULL.
+	v.Inject(Fault{Op: OpWrite, File: "-wal", Nth: 2, Code: sqlite3.SQLITE_FULL})
+	_, err = db.Exec(`INSERT INTO test (name) VALUES (?)`, "full")
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) || serr.Code() != sqlite3.SQLITE_FULL {
+		t.Fatalf("expected SQLITE_FULL, but got %v", err)
+	}
+
+	// The fault fired once, so the retry goes through.
+	if _, err := db.Exec(`INSERT INTO test (name) VALUES (?)`, "ok"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delay every sync of the WAL file.
+	v.Inject(Fault{Op: OpSync, File: "-wal", Delay: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := db.Exec(`INSERT INTO test (name) VALUES (?)`, "slow"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("expected the insert to take at least 50ms, but it took %v", d)
+	}
+	v.Reset()
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected count to be 2, but got %d", count)
+	}
+}
+
+func TestFaultyVFSDefault(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?vfs="+FaultyVFSName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	Faulty.Inject(Fault{Op: OpOpen, Code: sqlite3.SQLITE_CANTOPEN})
+	defer Faulty.Reset()
+	if err := db.Ping(); err == nil {
+		t.Fatal("expected open to fail")
+	}
+}
@@ -0,0 +1,106 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// stmtCache is the LRU cache of the prepared statements of a connection,
+// enabled by _stmt_cache. It holds idle statements only: a statement is
+// taken out of the cache while it runs or while a SQLiteStmt holds it, so
+// two uses of the same SQL never share one.
+type stmtCache struct {
+	size   int
+	lru    *list.List // of *cachedStmt, most recently used first
+	idle   map[string]*list.Element
+	closed bool
+}
+
+type cachedStmt struct {
+	query string
+	stmt  driver.Stmt
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, lru: list.New(), idle: map[string]*list.Element{}}
+}
+
+// take removes the statement of query from the cache and returns it, or nil
+// if there is none.
+func (sc *stmtCache) take(query string) driver.Stmt {
+	e, ok := sc.idle[query]
+	if !ok {
+		return nil
+	}
+	sc.lru.Remove(e)
+	delete(sc.idle, query)
+	return e.Value.(*cachedStmt).stmt
+}
+
+// put returns the statement of query to the cache, closing the least
+// recently used statement if the cache is full. s is closed instead if the
+// cache is closed or already holds a statement of query.
+func (sc *stmtCache) put(query string, s driver.Stmt) error {
+	if _, ok := sc.idle[query]; ok || sc.closed {
+		return s.Close()
+	}
+	sc.idle[query] = sc.lru.PushFront(&cachedStmt{query: query, stmt: s})
+	if sc.lru.Len() <= sc.size {
+		return nil
+	}
+	e := sc.lru.Back()
+	sc.lru.Remove(e)
+	old := e.Value.(*cachedStmt)
+	delete(sc.idle, old.query)
+	return old.stmt.Close()
+}
+
+// flush closes the statements of the cache.
+func (sc *stmtCache) flush() error {
+	var err error
+	for e := sc.lru.Front(); e != nil; e = e.Next() {
+		if cerr := e.Value.(*cachedStmt).stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	sc.lru.Init()
+	clear(sc.idle)
+	return err
+}
+
+// close flushes the cache and closes the statements returned to it later.
+func (sc *stmtCache) close() error {
+	sc.closed = true
+	return sc.flush()
+}
+
+// cachedPrepare returns the cached statement of query, preparing it on a
+// miss.
+func (c *SQLiteConn) cachedPrepare(ctx context.Context, query string) (driver.Stmt, error) {
+	if s := c.stmts.take(query); s != nil {
+		if c.metrics != nil {
+			c.metrics.stmtCacheHits.Add(1)
+		}
+		return s, nil
+	}
+	if c.metrics != nil {
+		c.metrics.stmtCacheMisses.Add(1)
+	}
+	return c.conn.PrepareContext(ctx, query)
+}
+
+// releaseStmt returns the statement of query to the cache once it ran with
+// the error err. A SQLITE_SCHEMA error means the schema changed under the
+// statements of the cache, so they are all closed.
+func (c *SQLiteConn) releaseStmt(query string, s driver.Stmt, err error) {
+	if code, ok := errorCode(err); ok && code&0xff == sqlite3.SQLITE_SCHEMA {
+		_ = s.Close()
+		_ = c.stmts.flush()
+		return
+	}
+	_ = c.stmts.put(query, s)
+}
@@ -0,0 +1,143 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestStmtCache(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	metrics := &Metrics{}
+	c := NewConnector("file:" + file + "?_stmt_cache=2")
+	c.Metrics = metrics
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expect := func(hits, misses int64) {
+		t.Helper()
+		s := metrics.Stats()
+		if s.StmtCacheHits != hits || s.StmtCacheMisses != misses {
+			t.Fatalf("expected %d hits and %d misses, but got %d and %d", hits, misses, s.StmtCacheHits, s.StmtCacheMisses)
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, n INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if _, err := conn.ExecContext(ctx, `INSERT INTO test (n) VALUES (?)`, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(4, 2)
+
+	// A statement is not shared while its rows are read.
+	const query = `SELECT n FROM test WHERE n >= ? ORDER BY n`
+	rows, err := conn.QueryContext(ctx, query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatal("expected a row")
+	}
+	var n int
+	if err := conn.QueryRowContext(ctx, query, 3).Scan(&n); err != nil || n != 3 {
+		t.Fatalf("expected 3, but got %d, %v", n, err)
+	}
+	if err := rows.Scan(&n); err != nil || n != 0 {
+		t.Fatalf("expected 0, but got %d, %v", n, err)
+	}
+	rows.Close()
+	if err := conn.QueryRowContext(ctx, query, 4).Scan(&n); err != nil || n != 4 {
+		t.Fatalf("expected 4, but got %d, %v", n, err)
+	}
+	expect(5, 4)
+
+	stmt, err := conn.PrepareContext(ctx, `INSERT INTO test (n) VALUES (?)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if _, err := stmt.ExecContext(ctx, 5+i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stmt.Close()
+	expect(6, 4)
+
+	// The least recently used statement is evicted.
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&n); err != nil || n != 7 {
+		t.Fatalf("expected 7 rows, but got %d, %v", n, err)
+	}
+	if err := conn.QueryRowContext(ctx, query, 6).Scan(&n); err != nil || n != 6 {
+		t.Fatalf("expected 6, but got %d, %v", n, err)
+	}
+	expect(6, 6)
+
+	// A cached statement follows changes of the schema.
+	columns := func() int {
+		t.Helper()
+		rows, err := conn.QueryContext(ctx, `SELECT * FROM test WHERE id = 1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(cols)
+	}
+	if n := columns(); n != 2 {
+		t.Fatalf("expected 2 columns, but got %d", n)
+	}
+	other, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.ExecContext(ctx, `ALTER TABLE test ADD COLUMN m INTEGER DEFAULT 7`); err != nil {
+		t.Fatal(err)
+	}
+	if n := columns(); n != 3 {
+		t.Fatalf("expected 3 columns, but got %d", n)
+	}
+	expect(7, 7)
+
+	var cache *stmtCache
+	err = conn.Raw(func(dc any) error {
+		c := dc.(*SQLiteConn)
+		cache = c.stmts
+		s, err := c.cachedPrepare(ctx, `SELECT 1`)
+		if err != nil {
+			return err
+		}
+		c.releaseStmt(`SELECT 1`, s, &resultError{msg: "schema changed", code: sqlite3.SQLITE_SCHEMA})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cache.lru.Len(); n != 0 {
+		t.Fatalf("expected SQLITE_SCHEMA to flush the cache, but it holds %d statements", n)
+	}
+
+	if _, err := conn.ExecContext(ctx, `DELETE FROM test`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	db.Close()
+	if !cache.closed || cache.lru.Len() != 0 {
+		t.Fatal("expected the cache to be closed with its connection")
+	}
+}
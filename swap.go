@@ -0,0 +1,216 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"weak"
+
+	"modernc.org/sqlite"
+)
+
+// SwapDatabase replaces the database file of db with the complete database
+// file newPath, which is moved in its place, without closing db.
+//
+// db must have been opened by a Connector, e.g. with sql.Open("sqlite3",
+// dsn) or sql.OpenDB. SwapDatabase checks that newPath is an existing
+// database with tables and checkpoints it, then stops db from opening
+// connections, closes the idle connections of the old file and waits for the
+// others to be returned to the pool, which closes them, or for ctx to be
+// done. Once they are all closed, it renames newPath over the old file,
+// after removing the -wal and -shm files left, and lets db open connections
+// again, on the new file. Queries waiting for a connection in the meantime
+// run on the new file once the swap completed.
+//
+// Connections held outside the pool, e.g. by a sql.Conn or a WriteQueue,
+// delay the swap until they are closed. No other process may have the
+// database open.
+func SwapDatabase(ctx context.Context, db *sql.DB, newPath string) error {
+	var c *Connector
+	var path string
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.Raw(func(dc any) error {
+		sc, ok := dc.(*SQLiteConn)
+		if !ok || sc.connector == nil {
+			return errors.New("sqlite3: swap needs a database opened by a sqlite3.Connector")
+		}
+		c = sc.connector
+		return sc.queryRow(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'", &path)
+	})
+	_ = conn.Close()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("sqlite3: swap needs a database file")
+	}
+	if err := checkpointFile(newPath); err != nil {
+		return fmt.Errorf("sqlite3: swap %s: %w", newPath, err)
+	}
+
+	c.swap.Lock()
+	defer c.swap.Unlock()
+	gen := c.gen.Add(1)
+	for {
+		// The connections in use are closed when they are returned, being
+		// stale.
+		c.closeIdle(gen)
+		if c.openBefore(gen) == 0 {
+			break
+		}
+		select {
+		case <-c.drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(newPath, path)
+}
+
+// checkpointFile checks that path is a database with tables and copies the
+// content of its write-ahead log, if any, into it. It does not create path,
+// so that a mistyped path does not replace the database with an empty one.
+func checkpointFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	conn, err := (&sqlite.Driver{}).Open("file:" + path + "?mode=rw")
+	if err != nil {
+		return err
+	}
+	c := conn.(sqliteConn)
+	sc := &SQLiteConn{conn: c}
+	var n int64
+	err = sc.queryRow(context.Background(), "SELECT COUNT(*) FROM sqlite_schema", &n)
+	if err == nil && n == 0 {
+		err = errors.New("database has no tables")
+	}
+	if err == nil {
+		_, err = c.Exec("PRAGMA wal_checkpoint(TRUNCATE)", nil)
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// States of a connection in the pool of its sql.DB. database/sql calls
+// IsValid when it returns a connection to the pool and ResetSession when it
+// takes it out again, so SwapDatabase can close the idle connections of the
+// old file without changing the settings of the pool.
+const (
+	connBusy int32 = iota
+	connIdle
+	connClosed
+)
+
+// idle marks c idle in the pool.
+func (c *SQLiteConn) idle() {
+	c.pool.CompareAndSwap(connBusy, connIdle)
+}
+
+// take marks c in use. It fails with driver.ErrBadConn, making database/sql
+// use another connection, if SwapDatabase closed c while it was idle.
+func (c *SQLiteConn) take() error {
+	if c.pool.CompareAndSwap(connIdle, connBusy) || c.pool.Load() != connClosed {
+		return nil
+	}
+	return driver.ErrBadConn
+}
+
+// closeIdle closes the idle connections of c open on database files older
+// than generation gen.
+func (c *Connector) closeIdle(gen int64) {
+	var idle []*SQLiteConn
+	c.mu.Lock()
+	for self, g := range c.conns {
+		if sc := self.Value(); sc != nil && g < gen && sc.pool.CompareAndSwap(connIdle, connClosed) {
+			idle = append(idle, sc)
+		}
+	}
+	c.mu.Unlock()
+	for _, sc := range idle {
+		_ = sc.Close()
+	}
+}
+
+// track registers sc as open on the current database file of c. The
+// registration does not keep sc reachable: a connection collected without
+// Close is forgotten by its cleanup.
+func (c *Connector) track(sc *SQLiteConn) {
+	sc.connector = c
+	sc.gen = c.gen.Load()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = map[weak.Pointer[SQLiteConn]]int64{}
+		c.drained = make(chan struct{}, 1)
+	}
+	self := weak.Make(sc)
+	c.conns[self] = sc.gen
+	sc.cleanups = append(sc.cleanups, runtime.AddCleanup(sc, c.forgetLeaked, leakedTrack{self: self, metrics: sc.metrics}))
+}
+
+// forget unregisters the closed connection sc.
+func (c *Connector) forget(sc *SQLiteConn) {
+	c.forgetConn(weak.Make(sc))
+}
+
+func (c *Connector) forgetConn(self weak.Pointer[SQLiteConn]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, self)
+	select {
+	case c.drained <- struct{}{}:
+	default:
+	}
+}
+
+// leakedTrack is what forgetLeaked needs of a SQLiteConn, which it must not
+// reference.
+type leakedTrack struct {
+	self    weak.Pointer[SQLiteConn]
+	metrics *Metrics
+}
+
+// forgetLeaked unregisters a connection collected without Close.
+func (c *Connector) forgetLeaked(l leakedTrack) {
+	if l.metrics != nil {
+		l.metrics.openConns.Add(-1)
+	}
+	c.forgetConn(l.self)
+}
+
+// openBefore returns the number of connections open on database files older
+// than generation gen.
+func (c *Connector) openBefore(gen int64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, g := range c.conns {
+		if g < gen {
+			n++
+		}
+	}
+	return n
+}
+
+// stale reports whether c is open on a database file SwapDatabase replaced.
+func (c *SQLiteConn) stale() bool {
+	return c.connector != nil && c.gen != c.connector.gen.Load()
+}
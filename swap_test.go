@@ -0,0 +1,142 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwapDatabase(t *testing.T) {
+	dir := t.TempDir()
+	file, newFile := filepath.Join(dir, "test.db"), filepath.Join(dir, "new.db")
+	ctx := context.Background()
+	for _, f := range []string{file, newFile} {
+		db, err := sql.Open("sqlite3", "file:"+f+"?_journal=WAL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `CREATE TABLE test (name TEXT); INSERT INTO test VALUES (?)`, filepath.Base(f)); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+file+"?_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(5)
+	name := func() string {
+		t.Helper()
+		var name string
+		if err := db.QueryRowContext(ctx, `SELECT name FROM test`).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	if n := name(); n != "test.db" {
+		t.Fatalf("expected test.db, but got %s", n)
+	}
+	// A statement prepared on the idle connections of the old file
+	// prepares itself again on the new one.
+	stmt, err := db.PrepareContext(ctx, `SELECT name FROM test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	// Idle connections of the old file are closed by the swap.
+	idle := make([]*sql.Conn, 3)
+	for i := range idle {
+		if idle[i], err = db.Conn(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range idle {
+		conn.Close()
+	}
+
+	// A connection in use delays the swap, and queries wait for it.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := make(chan error, 1)
+	go func() {
+		swapped <- SwapDatabase(ctx, db, newFile)
+	}()
+	names := make(chan string, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		names <- name()
+	}()
+	select {
+	case err := <-swapped:
+		t.Fatalf("expected the swap to wait for the connection, but got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	var n string
+	if err := conn.QueryRowContext(ctx, `SELECT name FROM test`).Scan(&n); err != nil || n != "test.db" {
+		t.Fatalf("expected test.db on the held connection, but got %s, %v", n, err)
+	}
+	conn.Close()
+	if err := <-swapped; err != nil {
+		t.Fatal(err)
+	}
+	if n := <-names; n != "new.db" {
+		t.Fatalf("expected the waiting query to read new.db, but got %s", n)
+	}
+	if n := name(); n != "new.db" {
+		t.Fatalf("expected new.db, but got %s", n)
+	}
+	if _, err := os.Stat(newFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the new file to be moved, but got %v", err)
+	}
+	if err := stmt.QueryRowContext(ctx).Scan(&n); err != nil || n != "new.db" {
+		t.Fatalf("expected the prepared statement to read new.db, but got %s, %v", n, err)
+	}
+	if n := db.Stats().MaxIdleClosed; n != 0 {
+		t.Fatalf("expected the swap to keep the idle connection limit, but %d connections were closed by it", n)
+	}
+	// The idle connections are kept again.
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		if conns[i], err = db.Conn(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if n := db.Stats().Idle; n != 3 {
+		t.Fatalf("expected 3 idle connections, but got %d", n)
+	}
+
+	// A missing file or a database without tables is refused, and the
+	// database is left as it is.
+	empty := filepath.Join(dir, "empty.db")
+	edb, err := sql.Open("sqlite3", "file:"+empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edb.ExecContext(ctx, `PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	edb.Close()
+	for _, f := range []string{filepath.Join(dir, "typo.db"), empty} {
+		if err := SwapDatabase(ctx, db, f); err == nil {
+			t.Fatalf("expected a swap with %s to fail", filepath.Base(f))
+		}
+		if n := name(); n != "new.db" {
+			t.Fatalf("expected new.db after a failed swap, but got %s", n)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "typo.db")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the missing file not to be created, but got %v", err)
+	}
+}
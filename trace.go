@@ -0,0 +1,220 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// Tracer observes every statement run on the connections of a Connector,
+// including Prepare and the BEGIN, COMMIT and ROLLBACK of transactions.
+//
+// OnQueryStart is called before the statement runs. The context it returns is
+// the one the statement runs with and the one passed to the matching
+// OnQueryEnd, so a Tracer can carry per-statement state in it.
+// OperationFromContext tells which driver call the statement belongs to and
+// ConnFromContext which connection runs it.
+//
+// OnQueryEnd is called once the statement returned, with its duration, the
+// number of rows it changed and its error. rowsAffected is -1 for queries,
+// Prepare and transaction control statements. For queries, OnQueryEnd is
+// called before the rows are iterated.
+//
+// A Tracer may implement ConnectTracer, TxTracer, RowsTracer and BusyTracer to
+// observe more of the life of a connection.
+type Tracer interface {
+	OnQueryStart(ctx context.Context, query string, args []driver.NamedValue) context.Context
+	OnQueryEnd(ctx context.Context, duration time.Duration, rowsAffected int64, err error)
+}
+
+// ConnectTracer is a Tracer that also observes connections being opened.
+// conn is nil if err is not.
+type ConnectTracer interface {
+	Tracer
+	OnConnect(ctx context.Context, conn *SQLiteConn, duration time.Duration, err error)
+}
+
+// TxTracer is a Tracer that also observes the lifetime of transactions.
+//
+// OnTxStart is called before BEGIN and the context it returns is the one the
+// BEGIN, COMMIT and ROLLBACK statements of the transaction are traced with.
+// OnTxEnd is called with it once the transaction committed, rolled back or
+// failed to begin.
+type TxTracer interface {
+	Tracer
+	OnTxStart(ctx context.Context, opts driver.TxOptions) context.Context
+	OnTxEnd(ctx context.Context, duration time.Duration, committed bool, err error)
+}
+
+// RowsTracer is a Tracer that also observes the iteration of query results.
+//
+// OnRowsEnd is called with the context of the query once its rows are closed,
+// with the time spent since the query returned, the number of rows read and
+// the error that ended the iteration, if any.
+type RowsTracer interface {
+	Tracer
+	OnRowsEnd(ctx context.Context, duration time.Duration, rows int64, err error)
+}
+
+// BusyTracer is a Tracer that also observes waits for a locked database.
+//
+// The driver replaces the busy handler set up by _busy_timeout with one that
+// waits just as long, unless the context of the statement is done, and calls
+// OnBusy after every wait, with the context of the statement running, if
+// any, the number of waits so far for the lock and the time just waited.
+type BusyTracer interface {
+	Tracer
+	OnBusy(ctx context.Context, count int, wait time.Duration)
+}
+
+// Operation is the driver call a traced statement is run by.
+type Operation string
+
+const (
+	OperationPrepare  Operation = "prepare"
+	OperationExec     Operation = "exec"
+	OperationQuery    Operation = "query"
+	OperationBegin    Operation = "begin"
+	OperationCommit   Operation = "commit"
+	OperationRollback Operation = "rollback"
+)
+
+type operationKey struct{}
+
+// OperationFromContext returns the Operation of the statement a Tracer is
+// called for, or "" if ctx does not belong to a traced statement.
+func OperationFromContext(ctx context.Context) Operation {
+	op, _ := ctx.Value(operationKey{}).(Operation)
+	return op
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the connection running the statement or the
+// transaction a Tracer is called for, or nil if ctx belongs to neither.
+func ConnFromContext(ctx context.Context) *SQLiteConn {
+	c, _ := ctx.Value(connKey{}).(*SQLiteConn)
+	return c
+}
+
+// Verb returns the upper-cased first keyword of query, e.g. "SELECT", which
+// Metrics groups statements by.
+func Verb(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	i := strings.IndexFunc(query, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	})
+	if i >= 0 {
+		query = query[:i]
+	}
+	return strings.ToUpper(query)
+}
+
+// stmtTrace is what traceStart records about a statement for traceEnd.
+type stmtTrace struct {
+	op    Operation
+	query string
+	args  []driver.NamedValue
+	start time.Time
+}
+
+// observed reports whether the statements of c are traced, measured or
+// checked for slowness.
+func (c *SQLiteConn) observed() bool {
+	return c.tracer != nil || c.metrics != nil || c.slowQuery > 0
+}
+
+func (c *SQLiteConn) traceStart(ctx context.Context, op Operation, query string, args []driver.NamedValue) (context.Context, stmtTrace) {
+	if !c.observed() {
+		return ctx, stmtTrace{}
+	}
+	if c.tracer != nil {
+		ctx = context.WithValue(context.WithValue(ctx, operationKey{}, op), connKey{}, c)
+		ctx = c.tracer.OnQueryStart(ctx, query, args)
+		c.stmtCtx = ctx
+	}
+	return ctx, stmtTrace{op: op, query: query, args: args, start: time.Now()}
+}
+
+func (c *SQLiteConn) traceEnd(ctx context.Context, st stmtTrace, rowsAffected int64, err error) {
+	if !c.observed() {
+		return
+	}
+	d := time.Since(st.start)
+	if c.metrics != nil {
+		c.metrics.statementEnd(c, st.op, st.query, d, err)
+	}
+	if st.op != OperationQuery || err != nil {
+		// Queries are checked once their rows are read.
+		c.checkSlowQuery(ctx, st, d)
+	}
+	if c.tracer != nil {
+		c.stmtCtx = nil
+		c.tracer.OnQueryEnd(ctx, d, rowsAffected, err)
+	}
+}
+
+func (c *SQLiteConn) txStart(ctx context.Context, opts driver.TxOptions) (context.Context, time.Time) {
+	t, ok := c.tracer.(TxTracer)
+	if !ok {
+		return ctx, time.Time{}
+	}
+	return t.OnTxStart(context.WithValue(ctx, connKey{}, c), opts), time.Now()
+}
+
+func (c *SQLiteConn) txEnd(ctx context.Context, start time.Time, committed bool, err error) {
+	if t, ok := c.tracer.(TxTracer); ok {
+		t.OnTxEnd(ctx, time.Since(start), committed, err)
+	}
+}
+
+// traceRows wraps the rows of the query st so that their iteration is
+// cancelled with ctx, by stop, and reported to a RowsTracer, Metrics and the
+// slow query log. release, if not nil, is called once the rows are closed.
+func (c *SQLiteConn) traceRows(ctx context.Context, st stmtTrace, rows driver.Rows, stop func(), release func(error)) driver.Rows {
+	if _, ok := c.tracer.(RowsTracer); !ok && c.metrics == nil && c.slowQuery == 0 && ctx.Done() == nil && release == nil {
+		stop()
+		return rows
+	}
+	return &SQLiteRows{c: c, rows: rows, ctx: ctx, st: st, stop: stop, release: release, start: time.Now()}
+}
+
+func (c *SQLiteConn) rowsEnd(ctx context.Context, st stmtTrace, start time.Time, n int64, err error) {
+	if c.metrics != nil {
+		c.metrics.cacheStats(c)
+	}
+	c.checkSlowQuery(ctx, st, time.Since(st.start))
+	if t, ok := c.tracer.(RowsTracer); ok {
+		t.OnRowsEnd(ctx, time.Since(start), n, err)
+	}
+}
+
+// busyWait reports a wait of the busy handler to the BusyTracer and Metrics.
+func (c *SQLiteConn) busyWait(count int, wait time.Duration) {
+	if c.metrics != nil {
+		c.metrics.busyWaits.Add(1)
+		c.metrics.busyWaitTime.Add(int64(wait))
+	}
+	if t, ok := c.tracer.(BusyTracer); ok {
+		ctx := c.stmtCtx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		t.OnBusy(ctx, count, wait)
+	}
+}
+
+// rowsAffected returns the number of rows r reports as changed, or -1 if it
+// is not known.
+func rowsAffected(r driver.Result) int64 {
+	if r == nil {
+		return -1
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,147 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type traceRecord struct {
+	query        string
+	rowsAffected int64
+	err          error
+}
+
+type recordingTracer struct {
+	mu      sync.Mutex
+	records []traceRecord
+}
+
+type traceKey struct{}
+
+func (r *recordingTracer) OnQueryStart(ctx context.Context, query string, args []driver.NamedValue) context.Context {
+	return context.WithValue(ctx, traceKey{}, query)
+}
+
+func (r *recordingTracer) OnQueryEnd(ctx context.Context, duration time.Duration, rowsAffected int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, traceRecord{query: ctx.Value(traceKey{}).(string), rowsAffected: rowsAffected, err: err})
+}
+
+func (r *recordingTracer) queries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var qs []string
+	for _, rec := range r.records {
+		qs = append(qs, rec.query)
+	}
+	return qs
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db"))
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO test (name) VALUES (?), (?)`, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareContext(ctx, `SELECT COUNT(*) FROM test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := stmt.QueryRowContext(ctx).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	if _, err := db.ExecContext(ctx, `INSERT INTO missing DEFAULT VALUES`); err == nil {
+		t.Fatal("expected insert into missing table to fail")
+	}
+
+	want := []string{
+		`CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)`,
+		`BEGIN`,
+		`INSERT INTO test (name) VALUES (?), (?)`,
+		`COMMIT`,
+		`SELECT COUNT(*) FROM test`,
+		`SELECT COUNT(*) FROM test`,
+		`INSERT INTO missing DEFAULT VALUES`,
+	}
+	if got := tracer.queries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected traced queries %q, but got %q", want, got)
+	}
+	if n := tracer.records[2].rowsAffected; n != 2 {
+		t.Fatalf("expected 2 rows affected, but got %d", n)
+	}
+	if err := tracer.records[6].err; err == nil {
+		t.Fatal("expected the failed insert to be traced with its error")
+	}
+}
+
+type busyTracer struct {
+	recordingTracer
+	waits []time.Duration
+}
+
+func (b *busyTracer) OnBusy(ctx context.Context, count int, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.waits = append(b.waits, wait)
+}
+
+func TestBusyTracer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	locker, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locker.Close()
+	if _, err := locker.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := locker.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO test DEFAULT VALUES`); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &busyTracer{}
+	c := NewConnector(file + "?_busy_timeout=100")
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO test DEFAULT VALUES`); err == nil {
+		t.Fatal("expected insert into a locked database to fail")
+	}
+	var total time.Duration
+	for _, w := range tracer.waits {
+		total += w
+	}
+	if len(tracer.waits) == 0 || total != 100*time.Millisecond {
+		t.Fatalf("expected busy waits adding up to 100ms, but got %v", tracer.waits)
+	}
+}
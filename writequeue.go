@@ -0,0 +1,282 @@
+// Package sqlite3 implements the functions, types, and interfaces for the module.
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWriteQueueClosed is returned by WriteQueue.Submit once the queue is closed.
+var ErrWriteQueueClosed = errors.New("sqlite3: write queue closed")
+
+// ErrWriteQueueBroken is returned by WriteQueue.Submit once a WriteFunc
+// panicked.
+var ErrWriteQueueBroken = errors.New("sqlite3: write queue broken by a panicking write")
+
+// errWriteRolledBack is returned for a WriteFunc that succeeded while a
+// statement rolled back the transaction of its batch.
+var errWriteRolledBack = errors.New("sqlite3: write queue transaction rolled back")
+
+// WriteFunc is a mutation submitted to a WriteQueue. It runs its statements
+// on conn, inside a savepoint of the transaction of its batch; it must not
+// begin, commit or roll back transactions itself.
+type WriteFunc func(ctx context.Context, conn *sql.Conn) error
+
+// WriteQueue serializes the mutations of many goroutines on a single
+// connection and commits them in groups: the WriteFuncs waiting when the
+// connection becomes free run in one BEGIN IMMEDIATE ... COMMIT, each in
+// its own savepoint. A WriteFunc failing rolls back its savepoint only, so
+// the others of its batch still commit, and one fsync is paid per batch
+// instead of per mutation.
+//
+// A WriteFunc panicking rolls back its batch and breaks the queue: the panic
+// propagates to its Submit, and the other WriteFuncs fail with
+// ErrWriteQueueBroken.
+type WriteQueue struct {
+	db       *sql.DB
+	conn     *sql.Conn // replaced when it goes bad
+	maxBatch int
+	reqs     chan *writeReq
+	broken   atomic.Bool
+
+	mu     sync.RWMutex // guards closed against Submit
+	closed bool
+	done   chan struct{}
+}
+
+type writeReq struct {
+	ctx   context.Context
+	fn    WriteFunc
+	err   chan error
+	panic any // recovered from fn, set before err is sent
+}
+
+// NewWriteQueue takes a connection of db for the writes, and another one if
+// it goes bad, and starts the queue. Batches hold up to maxBatch WriteFuncs,
+// 64 if maxBatch <= 0.
+func NewWriteQueue(ctx context.Context, db *sql.DB, maxBatch int) (*WriteQueue, error) {
+	if maxBatch <= 0 {
+		maxBatch = 64
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := &WriteQueue{
+		db:       db,
+		conn:     conn,
+		maxBatch: maxBatch,
+		reqs:     make(chan *writeReq, maxBatch),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q, nil
+}
+
+// Submit queues fn and waits until its batch committed. It returns the
+// error of fn, or of the transaction of the batch. If ctx is done before fn
+// runs, fn is skipped and ctx.Err() is returned. If fn panics, Submit panics
+// with the same value.
+func (q *WriteQueue) Submit(ctx context.Context, fn WriteFunc) error {
+	req := &writeReq{ctx: ctx, fn: fn, err: make(chan error, 1)}
+
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return ErrWriteQueueClosed
+	}
+	if q.broken.Load() {
+		q.mu.RUnlock()
+		return ErrWriteQueueBroken
+	}
+	select {
+	case q.reqs <- req:
+		q.mu.RUnlock()
+	case <-ctx.Done():
+		q.mu.RUnlock()
+		return ctx.Err()
+	}
+	err := <-req.err
+	if req.panic != nil {
+		panic(req.panic)
+	}
+	return err
+}
+
+// Close stops the queue once the WriteFuncs already submitted ran and
+// releases its connection.
+func (q *WriteQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.reqs)
+	q.mu.Unlock()
+
+	<-q.done
+	return q.conn.Close()
+}
+
+func (q *WriteQueue) run() {
+	defer close(q.done)
+	for req := range q.reqs {
+		if q.broken.Load() {
+			req.err <- ErrWriteQueueBroken
+			continue
+		}
+		batch := []*writeReq{req}
+	fill:
+		for len(batch) < q.maxBatch {
+			select {
+			case req, ok := <-q.reqs:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, req)
+			default:
+				break fill
+			}
+		}
+		q.commit(batch)
+	}
+}
+
+// commit runs batch in one transaction and reports the outcome of every
+// WriteFunc once the transaction ended.
+//
+// A WriteFunc whose statement rolls back the whole transaction, e.g. INSERT
+// OR ROLLBACK or one interrupted, undoes the WriteFuncs run before it: they
+// fail with its error, and the WriteFuncs after it run in a new transaction.
+func (q *WriteQueue) commit(batch []*writeReq) {
+	for len(batch) > 0 {
+		batch = q.commitUntilAbort(batch)
+	}
+}
+
+// commitUntilAbort runs batch in one transaction until a WriteFunc rolls it
+// back, and returns the WriteFuncs left to run.
+func (q *WriteQueue) commitUntilAbort(batch []*writeReq) []*writeReq {
+	// The transaction is not bound to the context of any submitter, so that
+	// one giving up does not abort the others.
+	ctx := context.Background()
+	errs := make([]error, len(batch))
+	if err := q.begin(ctx); err != nil {
+		for _, req := range batch {
+			req.err <- err
+		}
+		return nil
+	}
+
+	for i, req := range batch {
+		if err := req.ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = q.savepoint(i, req)
+		if req.panic != nil {
+			q.broken.Store(true)
+			_, _ = q.conn.ExecContext(ctx, "ROLLBACK")
+			for j, req := range batch {
+				if j != i {
+					errs[j] = ErrWriteQueueBroken
+				}
+				req.err <- errs[j]
+			}
+			return nil
+		}
+		if q.inTx() {
+			continue
+		}
+		for j, req := range batch[:i+1] {
+			if errs[j] == nil {
+				errs[j] = errs[i]
+			}
+			req.err <- errs[j]
+		}
+		return batch[i+1:]
+	}
+
+	var txErr error
+	if _, err := q.conn.ExecContext(ctx, "COMMIT"); err != nil {
+		txErr = err
+		_, _ = q.conn.ExecContext(ctx, "ROLLBACK")
+	}
+	for i, req := range batch {
+		if errs[i] == nil {
+			errs[i] = txErr
+		}
+		req.err <- errs[i]
+	}
+	return nil
+}
+
+// begin starts the transaction of a batch, on a new connection of db if the
+// one of q went bad, e.g. with driver.ErrBadConn, which database/sql does not
+// hand out again.
+func (q *WriteQueue) begin(ctx context.Context) error {
+	_, err := q.conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	if !errors.Is(err, driver.ErrBadConn) && !errors.Is(err, sql.ErrConnDone) {
+		return err
+	}
+	conn, cerr := q.db.Conn(ctx)
+	if cerr != nil {
+		return err
+	}
+	_ = q.conn.Close()
+	q.conn = conn
+	_, err = q.conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	return err
+}
+
+// savepoint runs the WriteFunc of req in savepoint wq_i, rolled back if the
+// WriteFunc fails. It returns errWriteRolledBack if the WriteFunc succeeded
+// but the transaction was rolled back. A panic of the WriteFunc is recovered
+// into req.panic, leaving the savepoint to the rollback of the batch.
+func (q *WriteQueue) savepoint(i int, req *writeReq) (err error) {
+	name := fmt.Sprintf("wq_%d", i)
+	if _, err := q.conn.ExecContext(req.ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			req.panic = r
+			err = ErrWriteQueueBroken
+			return
+		}
+		if !q.inTx() {
+			if err == nil {
+				err = errWriteRolledBack
+			}
+			return
+		}
+		ctx := context.Background()
+		if err != nil {
+			_, _ = q.conn.ExecContext(ctx, "ROLLBACK TO "+name)
+		}
+		if _, rerr := q.conn.ExecContext(ctx, "RELEASE "+name); err == nil {
+			err = rerr
+		}
+	}()
+	return req.fn(req.ctx, q.conn)
+}
+
+// inTx reports whether the transaction of the batch is still open. It
+// assumes so for connections of other drivers, and not once the connection
+// went bad.
+func (q *WriteQueue) inTx() bool {
+	in := true
+	err := q.conn.Raw(func(dc any) error {
+		if sc, ok := dc.(*SQLiteConn); ok {
+			in = sc.inTx()
+		}
+		return nil
+	})
+	return in && err == nil
+}
@@ -0,0 +1,278 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestWriteQueue(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewConnector("file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal=WAL")
+	c.Tracer = tracer
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY, n INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewWriteQueue(ctx, db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// Hold the writer until all the other writes are queued, so that they
+	// are committed as one batch.
+	const n = 20
+	running, release := make(chan struct{}), make(chan struct{})
+	held := make(chan error, 1)
+	go func() {
+		held <- q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			close(running)
+			<-release
+			_, err := conn.ExecContext(ctx, `INSERT INTO test (n) VALUES (-1)`)
+			return err
+		})
+	}()
+	<-running
+
+	errFailed := errors.New("failed")
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range n {
+		wg.Go(func() {
+			errs[i] = q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+				if _, err := conn.ExecContext(ctx, `INSERT INTO test (n) VALUES (?)`, i); err != nil {
+					return err
+				}
+				if i == 3 {
+					return errFailed
+				}
+				return nil
+			})
+		})
+	}
+	for len(q.reqs) < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+
+	for i, err := range errs {
+		if i == 3 {
+			if !errors.Is(err, errFailed) {
+				t.Fatalf("expected write 3 to fail, but got %v", err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM test WHERE n <> 3`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Fatalf("expected %d rows, but got %d", n, count)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM test WHERE n = 3`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected the failed write to be rolled back")
+	}
+
+	var commits int
+	for _, query := range tracer.queries() {
+		if query == "COMMIT" {
+			commits++
+		}
+	}
+	if commits != 2 {
+		t.Fatalf("expected 2 commits, but got %d", commits)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Submit(ctx, nil); !errors.Is(err, ErrWriteQueueClosed) {
+		t.Fatalf("expected ErrWriteQueueClosed, but got %v", err)
+	}
+}
+
+func TestWriteQueueRollback(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewWriteQueue(ctx, db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	running, release := make(chan struct{}), make(chan struct{})
+	held := make(chan error, 1)
+	go func() {
+		held <- q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			close(running)
+			<-release
+			return nil
+		})
+	}()
+	<-running
+
+	// The second write rolls back the transaction of the batch.
+	queries := []string{
+		`INSERT INTO test VALUES (1)`,
+		`INSERT OR ROLLBACK INTO test VALUES (1)`,
+		`INSERT INTO test VALUES (3)`,
+	}
+	errs := make([]chan error, len(queries))
+	for i, query := range queries {
+		errs[i] = make(chan error, 1)
+		go func() {
+			errs[i] <- q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+				_, err := conn.ExecContext(ctx, query)
+				return err
+			})
+		}()
+		for len(q.reqs) < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+
+	rollbackErr := <-errs[1]
+	if code, ok := errorCode(rollbackErr); !ok || code&0xff != sqlite3.SQLITE_CONSTRAINT {
+		t.Fatalf("expected a constraint error, but got %v", rollbackErr)
+	}
+	if err := <-errs[0]; err != rollbackErr {
+		t.Fatalf("expected the rolled back write to fail with %v, but got %v", rollbackErr, err)
+	}
+	if err := <-errs[2]; err != nil {
+		t.Fatalf("expected the write after the rollback to commit, but got %v", err)
+	}
+	var ids []int64
+	err = queryEach(ctx, db, func(rows *sql.Rows) error {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	}, `SELECT id FROM test ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != 3 {
+		t.Fatalf("expected the rows [3], but got %v", ids)
+	}
+}
+
+func TestWriteQueuePanic(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewWriteQueue(ctx, db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// The panic reaches the submitter, after the rollback of its write.
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected the panic boom, but got %v", r)
+			}
+		}()
+		_ = q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			if _, err := conn.ExecContext(ctx, `INSERT INTO test VALUES (1)`); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+		t.Fatal("expected Submit to panic")
+	}()
+	err = q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+		t.Fatal("expected the broken queue not to run writes")
+		return nil
+	})
+	if !errors.Is(err, ErrWriteQueueBroken) {
+		t.Fatalf("expected ErrWriteQueueBroken, but got %v", err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected the write to be rolled back, but got %d rows", count)
+	}
+}
+
+func TestWriteQueueBadConn(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE test (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewWriteQueue(ctx, db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// The driver connection is closed under the queue, which reports
+	// driver.ErrBadConn from then on.
+	err = q.conn.Raw(func(dc any) error {
+		return dc.(*SQLiteConn).Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		err := q.Submit(ctx, func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `INSERT INTO test VALUES (?)`, i)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("expected the write to run on a new connection, but got %v", err)
+		}
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 rows, but got %d", count)
+	}
+}